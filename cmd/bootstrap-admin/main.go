@@ -0,0 +1,112 @@
+// Command bootstrap-admin creates the first admin, later admins are appointed through the API.
+//
+//	go run ./cmd/bootstrap-admin -email admin@example.com
+//
+// An existing user with the email is promoted, otherwise a verified user is created with
+// the given password, or a generated one that is printed once.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/TechXTT/bazaar-backend/modules/users/pkg/passwords"
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/gofrs/uuid/v5"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+
+	// Services
+	_ "github.com/TechXTT/bazaar-backend/services/config"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the admin")
+	password := flag.String("password", "", "password of a new admin, generated when empty")
+	flag.Parse()
+
+	if *email == "" {
+		flag.Usage()
+		log.Fatal("-email is required")
+	}
+
+	database := do.MustInvoke[db.DB](app.Boot()).DB()
+
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return bootstrap(tx, *email, *password)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func bootstrap(tx *gorm.DB, email string, password string) error {
+	var admins int64
+	result := tx.Model(&db.Users{}).Where("role = ?", rbac.RoleAdmin).Count(&admins)
+	if result.Error != nil {
+		return result.Error
+	}
+	if admins > 0 {
+		return errors.New("an admin already exists, further admins are appointed with PUT /api/users/{id}/role")
+	}
+
+	var user db.Users
+	result = tx.Where("email = ?", email).Limit(1).Find(&user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		result = tx.Model(&db.Users{}).Where("id = ?", user.ID).Update("role", rbac.RoleAdmin)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		fmt.Printf("Promoted %s to admin\n", email)
+		return nil
+	}
+
+	generated := password == ""
+	if generated {
+		b := make([]byte, 18)
+		_, err := rand.Read(b)
+		if err != nil {
+			return err
+		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	hashedPassword, err := passwords.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	result = tx.Create(&db.Users{
+		ID:            id,
+		Email:         email,
+		EmailVerified: true,
+		Password:      hashedPassword,
+		Role:          string(rbac.RoleAdmin),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	fmt.Printf("Created admin %s\n", email)
+	if generated {
+		fmt.Printf("Password: %s\n", password)
+	}
+
+	return nil
+}
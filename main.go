@@ -7,7 +7,11 @@ import (
 
 	// Services
 	_ "github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
+	"github.com/TechXTT/bazaar-backend/services/notifications"
 	"github.com/TechXTT/bazaar-backend/services/observer"
+	"github.com/TechXTT/bazaar-backend/services/pricing"
 	"github.com/TechXTT/bazaar-backend/services/web"
 	_ "github.com/joho/godotenv/autoload"
 
@@ -21,9 +25,19 @@ import (
 func main() {
 	i := app.Boot()
 
-	// Start the observer
+	// Start one observer per network, each reconnects on its own when its subscription drops
 	ob := do.MustInvoke[observer.Observer](i)
-	go ob.RunSubscription("./Escrow.json")
+	go ob.Run()
+
+	// Deliver domain events recorded in the outbox to their listeners, mailing users about theirs
+	do.MustInvoke[notifications.Notifications](i).Listen()
+	go do.MustInvoke[events.Bus](i).Run()
+
+	// Send queued transactional mails, retrying while the mail backend is down
+	go do.MustInvoke[mailer.Mailer](i).Run()
+
+	// Keep the exchange rates used for fiat display prices fresh
+	go do.MustInvoke[pricing.Pricing](i).Run()
 
 	server := do.MustInvoke[web.Web](i)
 	err := server.Start()
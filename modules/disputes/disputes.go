@@ -5,7 +5,9 @@ import (
 	"net/http"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
 	"github.com/TechXTT/bazaar-backend/services/middleware"
 	"github.com/TechXTT/bazaar-backend/services/s3spaces"
 	"github.com/TechXTT/bazaar-backend/services/web"
@@ -22,6 +24,9 @@ type (
 
 		CloseDispute(userId string, id string) error
 
+		// ResolveDispute closes any open dispute, for moderators arbitrating it
+		ResolveDispute(moderatorId string, id string) error
+
 		CreateDisputeImage(userId string, d *DisputeImages) error
 
 		SaveFile(file *multipart.FileHeader, bucket string) (string, error)
@@ -34,11 +39,14 @@ type (
 		GetDispute(w http.ResponseWriter, r *http.Request)
 		// CloseDispute handles a request to close a dispute
 		CloseDispute(w http.ResponseWriter, r *http.Request)
+		// ResolveDispute handles a moderator's request to resolve a dispute
+		ResolveDispute(w http.ResponseWriter, r *http.Request)
 	}
 
 	disputesService struct {
 		db       db.DB
 		s3spaces s3spaces.S3Spaces
+		events   events.Bus
 	}
 
 	disputesHandler struct {
@@ -65,5 +73,6 @@ func init() {
 		authenticatedHandler.HandleFunc("/disputes", h.CreateDispute).Methods("POST")
 		authenticatedHandler.HandleFunc("/disputes/order/{id}", h.GetDispute).Methods("GET")
 		authenticatedHandler.HandleFunc("/disputes/{id}", h.CloseDispute).Methods("PUT")
+		authenticatedHandler.Handle("/disputes/{id}/resolve", middleware.RequirePermission(rbac.PermissionResolveDisputes)(http.HandlerFunc(h.ResolveDispute))).Methods("PUT")
 	})
 }
@@ -2,6 +2,7 @@ package disputes
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -90,3 +91,20 @@ func (d *disputesHandler) CloseDispute(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (d *disputesHandler) ResolveDispute(w http.ResponseWriter, r *http.Request) {
+	moderatorId := r.Header.Get("user_id")
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := d.svc.ResolveDispute(moderatorId, id); err != nil {
+		if errors.Is(err, ErrDisputeNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
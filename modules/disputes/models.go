@@ -1,6 +1,7 @@
 package disputes
 
 import (
+	"github.com/TechXTT/bazaar-backend/pkg/money"
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
@@ -47,12 +48,12 @@ type Users struct {
 
 type Orders struct {
 	gorm.Model
-	ID        uuid.UUID `gorm:"primaryKey"`
-	ProductID uuid.UUID `gorm:"not null"`
-	BuyerID   uuid.UUID `gorm:"not null"`
-	Buyer     Users     `gorm:"foreignKey:BuyerID"`
-	Quantity  int       `gorm:"not null"`
-	Total     float64   `gorm:"not null"`
+	ID        uuid.UUID    `gorm:"primaryKey"`
+	ProductID uuid.UUID    `gorm:"not null"`
+	BuyerID   uuid.UUID    `gorm:"not null"`
+	Buyer     Users        `gorm:"foreignKey:BuyerID"`
+	Quantity  int          `gorm:"not null"`
+	Total     money.Amount `gorm:"not null"`
 	TxHash    string
 	// TODO: add tracking number and shipping address for orders, and txHash for payment
 }
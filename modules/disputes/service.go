@@ -5,11 +5,16 @@ import (
 	"mime/multipart"
 
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
 	"github.com/TechXTT/bazaar-backend/services/s3spaces"
+	"github.com/gofrs/uuid/v5"
 	"github.com/samber/do"
 	"gorm.io/gorm"
 )
 
+// ErrDisputeNotFound is returned when resolving a dispute that does not exist or is already resolved
+var ErrDisputeNotFound = errors.New("dispute not found")
+
 type DisputeRequest struct {
 	OrderID string `json:"orderID"`
 	Dispute string `json:"dispute"`
@@ -18,10 +23,12 @@ type DisputeRequest struct {
 func NewDisputesService(i *do.Injector) (Service, error) {
 	db := do.MustInvoke[db.DB](i)
 	s3spaces := do.MustInvoke[s3spaces.S3Spaces](i)
+	events := do.MustInvoke[events.Bus](i)
 
 	w := &disputesService{
 		db:       db,
 		s3spaces: s3spaces,
+		events:   events,
 	}
 	return w, nil
 }
@@ -61,10 +68,21 @@ func (w *disputesService) CreateDispute(userId string, d *Disputes) (string, err
 		Dispute: d.Dispute,
 	}
 
-	res := db.Create(&dispute)
-	if res.Error != nil {
-		return "", res.Error
+	txErr := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&dispute).Error; err != nil {
+			return err
+		}
+
+		return w.events.Record(tx, events.DisputeOpened{
+			DisputeID: dispute.ID,
+			OrderID:   dispute.OrderID,
+			UserID:    uuid.FromStringOrNil(userId),
+		})
+	})
+	if txErr != nil {
+		return "", txErr
 	}
+	w.events.Notify()
 
 	return dispute.ID.String(), nil
 }
@@ -182,10 +200,57 @@ func (w *disputesService) CloseDispute(userId string, id string) error {
 	}
 
 	dispute.Resolved = true
-	res := db.Save(&dispute)
-	if res.Error != nil {
-		return res.Error
+	txErr := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&dispute).Error; err != nil {
+			return err
+		}
+
+		return w.events.Record(tx, events.DisputeResolved{
+			DisputeID: dispute.ID,
+			OrderID:   dispute.OrderID,
+			UserID:    uuid.FromStringOrNil(userId),
+		})
+	})
+	if txErr != nil {
+		return txErr
+	}
+	w.events.Notify()
+
+	return nil
+}
+
+func (w *disputesService) ResolveDispute(moderatorId string, id string) error {
+	db := w.db.DB()
+
+	var dispute Disputes
+	result := db.Where("id = ? AND resolved = ?", id, false).Limit(1).Find(&dispute)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDisputeNotFound
+	}
+
+	txErr := db.Transaction(func(tx *gorm.DB) error {
+		// Matching on resolved keeps a dispute closed concurrently by its parties from resolving twice
+		result := tx.Model(&Disputes{}).Where("id = ? AND resolved = ?", dispute.ID, false).Update("resolved", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrDisputeNotFound
+		}
+
+		return w.events.Record(tx, events.DisputeResolved{
+			DisputeID: dispute.ID,
+			OrderID:   dispute.OrderID,
+			UserID:    uuid.FromStringOrNil(moderatorId),
+		})
+	})
+	if txErr != nil {
+		return txErr
 	}
+	w.events.Notify()
 
 	return nil
 }
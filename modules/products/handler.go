@@ -2,10 +2,18 @@ package products
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/middleware"
+	"github.com/TechXTT/bazaar-backend/services/observer"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/TechXTT/bazaar-backend/services/pricing"
 	"github.com/gofrs/uuid/v5"
 	"github.com/gorilla/mux"
 	"github.com/samber/do"
@@ -22,6 +30,18 @@ type (
 	OrderRequest struct {
 		Data []DataRequest `json:"data"`
 	}
+
+	OrderStatusRequest struct {
+		Status OrderStatus `json:"status"`
+	}
+
+	OrderTransactionRequest struct {
+		TxHash string `json:"tx_hash"`
+	}
+
+	ClaimRequest struct {
+		OrderIDs []string `json:"order_ids"`
+	}
 )
 
 // NewProductsHandler creates a new users handler
@@ -38,6 +58,13 @@ func (s *productsHandler) Gets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		if err := s.svc.PriceProducts(products, currency); err != nil {
+			priceError(w, err)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	if err := json.NewEncoder(w).Encode(products); err != nil {
@@ -56,6 +83,15 @@ func (s *productsHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		products := []Products{*product}
+		if err := s.svc.PriceProducts(products, currency); err != nil {
+			priceError(w, err)
+			return
+		}
+		product = &products[0]
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(product)
@@ -77,16 +113,35 @@ func (s *productsHandler) Create(w http.ResponseWriter, r *http.Request) {
 	// read from form data
 	product.Name = r.FormValue("name")
 	product.Description = r.FormValue("description")
-	product.Price, err = strconv.ParseFloat(r.FormValue("price"), 64)
+	// Prices are exact amounts of the currency's smallest unit, such as wei
+	product.Price, err = money.Parse(r.FormValue("price"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	product.Unit = "ETH" // TODO: future implementation to allow other coins
 	product.StoreID = uuid.FromStringOrNil(r.FormValue("storeId"))
 
+	// The unit is the network currency unless a whitelisted token symbol is given,
+	// the network defaults to the first configured one
+	product.Unit = r.FormValue("unit")
+	if chainId := r.FormValue("chainId"); chainId != "" {
+		product.ChainID, err = strconv.ParseUint(chainId, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	id, err := s.svc.CreateProduct(userId, product)
 	if err != nil {
+		if errors.Is(err, escrow.ErrUnknownNetwork) || errors.Is(err, escrow.ErrUnknownToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, ErrTwoFactorRequired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -146,6 +201,24 @@ func (s *productsHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (s *productsHandler) TakeDown(w http.ResponseWriter, r *http.Request) {
+	moderatorId := r.Header.Get("user_id")
+
+	vars := mux.Vars(r)
+	productId := vars["id"]
+
+	if err := s.svc.TakeDownProduct(moderatorId, productId); err != nil {
+		if errors.Is(err, ErrProductNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *productsHandler) GetFromStore(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	storeId := vars["id"]
@@ -173,6 +246,13 @@ func (s *productsHandler) GetFromStore(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		if err := s.svc.PriceProducts(products, currency); err != nil {
+			priceError(w, err)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("next-cursor", products[len(products)-1].CreatedAt.String())
 
@@ -195,6 +275,10 @@ func (s *productsHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	// Assign the returned values from s.svc.CreateOrders to separate variables
 	orderIds, err := s.svc.CreateOrders(userId, orders.Data)
 	if err != nil {
+		if errors.Is(err, ErrInvalidQuantity) || errors.Is(err, escrow.ErrUnknownToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -214,6 +298,13 @@ func (s *productsHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		if err := s.svc.PriceOrders(orders, currency); err != nil {
+			priceError(w, err)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
@@ -228,6 +319,157 @@ func (s *productsHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		orders := []Orders{*order}
+		if err := s.svc.PriceOrders(orders, currency); err != nil {
+			priceError(w, err)
+			return
+		}
+		order = &orders[0]
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(order)
 }
+
+func (s *productsHandler) GetOrderHistory(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("user_id")
+
+	vars := mux.Vars(r)
+	orderId := vars["id"]
+
+	// Admins read any order's history, the permission comes from the access token
+	history, err := s.svc.GetOrderHistory(userId, orderId, middleware.Can(r, rbac.PermissionViewOrders))
+	if err != nil {
+		switch err.Error() {
+		case "order not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "unauthorized":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func (s *productsHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("user_id")
+
+	vars := mux.Vars(r)
+	orderId := vars["id"]
+
+	body := &OrderStatusRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.svc.UpdateOrderStatus(userId, orderId, body.Status); err != nil {
+		switch {
+		case err.Error() == "order not found", errors.Is(err, orderstate.ErrOrderNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err.Error() == "unauthorized":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, orderstate.ErrIllegalTransition), errors.Is(err, orderstate.ErrStatusChanged):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *productsHandler) VerifyOrderTransaction(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("user_id")
+
+	vars := mux.Vars(r)
+	orderId := vars["id"]
+
+	body := &OrderTransactionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.svc.VerifyOrderTransaction(userId, orderId, body.TxHash)
+	if err != nil {
+		switch {
+		case err.Error() == "order not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err.Error() == "unauthorized":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case err.Error() == "invalid transaction hash",
+			errors.Is(err, observer.ErrTransactionNotFound),
+			errors.Is(err, observer.ErrTransactionFailed),
+			errors.Is(err, observer.ErrDepositNotFound):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, observer.ErrDepositMismatch):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+func (s *productsHandler) GetClaimableOrders(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("user_id")
+
+	orders, err := s.svc.GetClaimableOrders(userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+func (s *productsHandler) ClaimOrders(w http.ResponseWriter, r *http.Request) {
+	userId := r.Header.Get("user_id")
+
+	body := &ClaimRequest{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claim, err := s.svc.ClaimOrders(userId, body.OrderIDs)
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorRequired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err.Error() == "no orders selected" || err.Error() == "some orders are not claimable" || err.Error() == "orders settle on different networks" {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
+
+// priceError writes the response for a display price that could not be computed
+func priceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pricing.ErrUnsupportedCurrency):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, pricing.ErrRatesUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
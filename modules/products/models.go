@@ -1,6 +1,10 @@
 package products
 
 import (
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/pricing"
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
@@ -9,21 +13,26 @@ type OrderStatus string
 
 const (
 	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusShipped   OrderStatus = "shipped"
 	OrderStatusCompleted OrderStatus = "completed"
-	OrderStatusCancelled OrderStatus = "cancelled"
 	OrderStatusReleased  OrderStatus = "released"
+	OrderStatusRefunded  OrderStatus = "refunded"
+	OrderStatusCancelled OrderStatus = "cancelled"
 )
 
 type Users struct {
 	gorm.Model
-	ID            uuid.UUID `gorm:"primaryKey"`
-	FirstName     string    `gorm:"not null"`
-	LastName      string    `gorm:"not null"`
-	Address       string
-	Email         string `gorm:"not null, unique"`
-	EmailVerified bool   `gorm:"default:false"`
-	Password      string `gorm:"not null"`
-	WalletAddress string
+	ID                 uuid.UUID `gorm:"primaryKey"`
+	FirstName          string    `gorm:"not null"`
+	LastName           string    `gorm:"not null"`
+	Address            string
+	Email              string `gorm:"not null, unique"`
+	EmailVerified      bool   `gorm:"default:false"`
+	Password           string `gorm:"not null"`
+	WalletAddress      string
+	WalletVerifiedAt   *time.Time
+	TwoFactorEnabledAt *time.Time
 }
 
 type Stores struct {
@@ -37,31 +46,63 @@ type Stores struct {
 
 type Products struct {
 	gorm.Model
-	ID    uuid.UUID `gorm:"primaryKey"`
-	Name  string    `gorm:"not null"`
-	Price float64   `gorm:"not null"`
-	Unit  string    `gorm:"not null"`
+	ID    uuid.UUID    `gorm:"primaryKey"`
+	Name  string       `gorm:"not null"`
+	Price money.Amount `gorm:"not null"`
+	Unit  string       `gorm:"not null"`
 
 	ImageURL string
 	// TODO: Define options for products
 	Description string    `gorm:"not null"`
 	StoreID     uuid.UUID `gorm:"not null"`
 	Store       Stores    `gorm:"foreignKey:StoreID"`
+	// ChainID is the network the product is sold on, its Unit is that network's currency
+	// or the symbol of a whitelisted token
+	ChainID uint64 `gorm:"not null;default:0"`
+	// Token is the address of the ERC-20 token the product is priced in, empty for the native currency
+	Token string
+	// TakenDownBy is the moderator who removed the product, set with TakenDownAt on takedown
+	TakenDownBy *uuid.UUID `gorm:"type:uuid" json:"-"`
+	TakenDownAt *time.Time `json:"-"`
+	// DisplayPrice is the price in the fiat currency a request asked for
+	DisplayPrice *pricing.DisplayPrice `gorm:"-" json:"DisplayPrice,omitempty"`
 }
 
 type Orders struct {
 	gorm.Model
-	ID        uuid.UUID   `gorm:"primaryKey"`
-	ProductID uuid.UUID   `gorm:"not null"`
-	Product   Products    `gorm:"foreignKey:ProductID"`
-	BuyerID   uuid.UUID   `gorm:"not null"`
-	Quantity  int         `gorm:"not null"`
-	Total     float64     `gorm:"not null"`
-	Status    OrderStatus `gorm:"not null, type:ENUM('pending', 'completed', 'cancelled', 'released'), default:'pending'"`
+	ID        uuid.UUID    `gorm:"primaryKey"`
+	ProductID uuid.UUID    `gorm:"not null"`
+	Product   Products     `gorm:"foreignKey:ProductID"`
+	BuyerID   uuid.UUID    `gorm:"not null"`
+	Quantity  int          `gorm:"not null"`
+	Total     money.Amount `gorm:"not null"`
+	Status    OrderStatus  `gorm:"not null, type:ENUM('pending', 'paid', 'shipped', 'completed', 'released', 'refunded', 'cancelled'), default:'pending'"`
 	TxHash    string
+	// ReleaseTime is when the seller can claim the escrowed funds
+	ReleaseTime *time.Time
+	// ChainID is the network the order settles on, copied from its product
+	ChainID uint64 `gorm:"not null;default:0"`
+	// Token is the ERC-20 token the order is paid in, copied from its product
+	Token string
+	// DisplayTotal is the total in the fiat currency a request asked for
+	DisplayTotal *pricing.DisplayPrice `gorm:"-" json:"DisplayTotal,omitempty"`
 	// TODO: add tracking number and shipping address for orders, and txHash for payment
 }
 
+type OrderStatusHistory struct {
+	gorm.Model
+	ID         uuid.UUID   `gorm:"primaryKey"`
+	OrderID    uuid.UUID   `gorm:"not null"`
+	FromStatus OrderStatus `gorm:"not null"`
+	ToStatus   OrderStatus `gorm:"not null"`
+	Actor      string      `gorm:"not null"`
+	Source     string      `gorm:"not null"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
 func (o *Orders) BeforeCreate(tx *gorm.DB) (err error) {
 	o.ID, err = uuid.NewV4()
 	o.Status = OrderStatusPending
@@ -5,8 +5,15 @@ import (
 	"net/http"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/events"
 	"github.com/TechXTT/bazaar-backend/services/middleware"
+	"github.com/TechXTT/bazaar-backend/services/observer"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/TechXTT/bazaar-backend/services/pricing"
 	"github.com/TechXTT/bazaar-backend/services/s3spaces"
 	"github.com/TechXTT/bazaar-backend/services/web"
 	"github.com/gorilla/mux"
@@ -32,6 +39,9 @@ type (
 		// DeleteProduct deletes a product
 		DeleteProduct(userId string, id string) error
 
+		// TakeDownProduct removes any product, for moderators
+		TakeDownProduct(moderatorId string, id string) error
+
 		// GetProductsFromStore returns paginated products from a store
 		GetProductsFromStore(storeId string, cursor string, limit int) ([]Products, error)
 
@@ -47,6 +57,28 @@ type (
 		// GetOrder returns an order by id
 		GetOrder(id string) (*Orders, error)
 
+		// GetOrderHistory returns the status changes of an order to its buyer, its seller or a
+		// user allowed to view all orders
+		GetOrderHistory(userId string, id string, viewAll bool) ([]OrderStatusHistory, error)
+
+		// UpdateOrderStatus moves an order to a status the user is allowed to set
+		UpdateOrderStatus(userId string, id string, status OrderStatus) error
+
+		// VerifyOrderTransaction checks the escrow deposit a buyer made for an order
+		VerifyOrderTransaction(userId string, id string, txHash string) (*Orders, error)
+
+		// GetClaimableOrders returns the seller's funded orders past their release time
+		GetClaimableOrders(userId string) ([]Orders, error)
+
+		// ClaimOrders returns the claimOrders transaction for a batch of claimable orders
+		ClaimOrders(userId string, orderIds []string) (*ClaimResponse, error)
+
+		// PriceProducts sets the display price of products in a fiat currency
+		PriceProducts(products []Products, currency string) error
+
+		// PriceOrders sets the display total of orders in a fiat currency
+		PriceOrders(orders []Orders, currency string) error
+
 		// TODO: Add methods for categories and orders
 	}
 
@@ -68,6 +100,9 @@ type (
 		// Delete handles a request to delete a product
 		Delete(w http.ResponseWriter, r *http.Request)
 
+		// TakeDown handles a moderator's request to remove a product
+		TakeDown(w http.ResponseWriter, r *http.Request)
+
 		// GetFromStore handles a request to get products from a store using pagination and store id
 		GetFromStore(w http.ResponseWriter, r *http.Request)
 
@@ -79,11 +114,32 @@ type (
 
 		// GetOrder handles a request to get an order
 		GetOrder(w http.ResponseWriter, r *http.Request)
+
+		// GetOrderHistory handles a request to get the status changes of an order
+		GetOrderHistory(w http.ResponseWriter, r *http.Request)
+
+		// UpdateOrderStatus handles a request to change an order status
+		UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
+
+		// VerifyOrderTransaction handles a request to submit the escrow transaction of an order
+		VerifyOrderTransaction(w http.ResponseWriter, r *http.Request)
+
+		// GetClaimableOrders handles a request to list the orders a seller can claim
+		GetClaimableOrders(w http.ResponseWriter, r *http.Request)
+
+		// ClaimOrders handles a request to build the claim transaction for orders
+		ClaimOrders(w http.ResponseWriter, r *http.Request)
 	}
 
 	productsService struct {
-		db       db.DB
-		s3spaces s3spaces.S3Spaces
+		db         db.DB
+		s3spaces   s3spaces.S3Spaces
+		orderState orderstate.OrderState
+		observer   observer.Observer
+		escrow     escrow.Escrow
+		pricing    pricing.Pricing
+		events     events.Bus
+		cfg        config.Config
 	}
 
 	productsHandler struct {
@@ -107,7 +163,9 @@ func init() {
 		authenticatedHandler.Use(middleware.AuthMiddleware)
 
 		authenticatedHandler.HandleFunc("/products/orders", h.GetOrders).Methods("GET")
+		authenticatedHandler.HandleFunc("/products/orders/claimable", h.GetClaimableOrders).Methods("GET")
 		e.Msg.HandleFunc(("/products/orders/{id}"), h.GetOrder).Methods("GET")
+		authenticatedHandler.HandleFunc("/products/orders/{id}/history", h.GetOrderHistory).Methods("GET")
 
 		e.Msg.HandleFunc("/products", h.Gets).Methods("GET")
 		e.Msg.HandleFunc("/products/store/{id}", h.GetFromStore).Methods("GET")
@@ -116,8 +174,12 @@ func init() {
 		authenticatedHandler.HandleFunc("/products", h.Create).Methods("POST")
 		authenticatedHandler.HandleFunc("/products/{id}", h.Update).Methods("PUT")
 		authenticatedHandler.HandleFunc("/products/{id}", h.Delete).Methods("DELETE")
+		authenticatedHandler.Handle("/products/{id}/takedown", middleware.RequirePermission(rbac.PermissionTakeDownProducts)(http.HandlerFunc(h.TakeDown))).Methods("DELETE")
 
 		authenticatedHandler.HandleFunc("/products/orders", h.CreateOrder).Methods("POST")
+		authenticatedHandler.HandleFunc("/products/orders/{id}/status", h.UpdateOrderStatus).Methods("PUT")
+		authenticatedHandler.HandleFunc("/products/orders/{id}/tx", h.VerifyOrderTransaction).Methods("POST")
+		authenticatedHandler.HandleFunc("/products/orders/claim", h.ClaimOrders).Methods("POST")
 
 	})
 }
@@ -3,28 +3,81 @@ package products
 import (
 	"errors"
 	"mime/multipart"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/observer"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/TechXTT/bazaar-backend/services/pricing"
 	"github.com/TechXTT/bazaar-backend/services/s3spaces"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gofrs/uuid/v5"
 	"github.com/samber/do"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+var (
+	// ErrProductNotFound is returned when taking down a product that does not exist
+	ErrProductNotFound = errors.New("product not found")
+	// ErrInvalidQuantity is returned for an order of less than one item
+	ErrInvalidQuantity = errors.New("quantity must be at least 1")
+	// ErrTwoFactorRequired is returned for seller actions of users without two-factor
+	// authentication when it is mandatory for sellers
+	ErrTwoFactorRequired = errors.New("two-factor authentication required for sellers")
+)
+
 type OrderResponse struct {
 	ID           string `json:"id"`
 	OwnerAddress string `json:"owner_address"`
+	ChainID      uint64 `json:"chain_id"`
+
+	// Everything the buyer needs to fund the order through the escrow
+	EscrowOrderID   string `json:"escrow_order_id"`
+	ContractAddress string `json:"contract_address"`
+	Value           string `json:"value"`
+	ReleaseTime     int64  `json:"release_time"`
+	Calldata        string `json:"calldata"`
+
+	// Token orders are funded by sending ApproveCalldata to the token before Calldata to the escrow
+	TokenAddress    string `json:"token_address,omitempty"`
+	ApproveCalldata string `json:"approve_calldata,omitempty"`
+}
+
+type ClaimResponse struct {
+	ChainID  uint64   `json:"chain_id"`
+	To       string   `json:"to"`
+	Data     string   `json:"data"`
+	OrderIDs []string `json:"order_ids"`
 }
 
 // NewProductsService creates a new users service
 func NewProductsService(i *do.Injector) (Service, error) {
 	db := do.MustInvoke[db.DB](i)
 	s3spaces := do.MustInvoke[s3spaces.S3Spaces](i)
+	orderState := do.MustInvoke[orderstate.OrderState](i)
+	observer := do.MustInvoke[observer.Observer](i)
+	escrow := do.MustInvoke[escrow.Escrow](i)
+	pricing := do.MustInvoke[pricing.Pricing](i)
+	events := do.MustInvoke[events.Bus](i)
+	cfg := do.MustInvoke[config.Config](i)
 
 	return &productsService{
-		db:       db,
-		s3spaces: s3spaces,
+		db:         db,
+		s3spaces:   s3spaces,
+		orderState: orderState,
+		observer:   observer,
+		escrow:     escrow,
+		pricing:    pricing,
+		events:     events,
+		cfg:        cfg,
 	}, nil
 }
 
@@ -72,6 +125,29 @@ func (p *productsService) DeleteProduct(userId string, id string) error {
 	return nil
 }
 
+func (p *productsService) TakeDownProduct(moderatorId string, id string) error {
+	moderator, err := uuid.FromString(moderatorId)
+	if err != nil {
+		return err
+	}
+
+	// The takedown is recorded on the product so it can be audited after the removal
+	return p.db.DB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Products{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"taken_down_by": moderator,
+			"taken_down_at": time.Now(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrProductNotFound
+		}
+
+		return tx.Where("id = ?", id).Delete(&Products{}).Error
+	})
+}
+
 func (p *productsService) GetProductsFromStore(storeId string, cursor string, limit int) ([]Products, error) {
 	var products []Products
 	db := p.db.DB()
@@ -96,6 +172,10 @@ func (p *productsService) CreateOrders(userId string, ordersData []DataRequest)
 
 	orders := []Orders{}
 	for _, orderData := range ordersData {
+		if orderData.Quantity <= 0 {
+			return nil, ErrInvalidQuantity
+		}
+
 		order := Orders{
 			ProductID: orderData.ProductID,
 			Quantity:  orderData.Quantity,
@@ -119,6 +199,10 @@ func (p *productsService) CreateOrders(userId string, ordersData []DataRequest)
 			return nil, errors.New("owner wallet address not found")
 		}
 
+		if owner.WalletVerifiedAt == nil {
+			return nil, errors.New("owner wallet address not verified")
+		}
+
 		if strings.EqualFold(owner.WalletAddress, ordersData[i].BuyerAddress) {
 			return nil, errors.New("owner and buyer cannot be the same")
 		}
@@ -127,12 +211,89 @@ func (p *productsService) CreateOrders(userId string, ordersData []DataRequest)
 			return nil, errors.New("owner and buyer cannot be the same")
 		}
 
-		order.Total = float64(order.Quantity) * product.Price
-		if err := db.Create(&order).Error; err != nil {
+		order.Total, err = product.Price.Mul(int64(order.Quantity))
+		if err != nil {
 			return nil, err
 		}
 
-		orderResponses = append(orderResponses, OrderResponse{ID: order.ID.String(), OwnerAddress: owner.WalletAddress})
+		contractAddress, err := p.escrow.Address(product.ChainID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The token may have been delisted or the escrow replaced by an ether only one since listing
+		if product.Token != "" {
+			_, err = p.escrow.Token(product.ChainID, product.Unit)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		releaseTime := time.Now().Add(p.escrow.ReleaseDelay()).Truncate(time.Second)
+		order.ReleaseTime = &releaseTime
+		order.ChainID = product.ChainID
+		order.Token = product.Token
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&order).Error; err != nil {
+				return err
+			}
+
+			return p.events.Record(tx, events.OrderCreated{
+				OrderID:   order.ID,
+				ProductID: order.ProductID,
+				BuyerID:   order.BuyerID,
+				ChainID:   order.ChainID,
+				Total:     order.Total.String(),
+				Token:     order.Token,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		p.events.Notify()
+
+		escrowOrderId := escrow.OrderID(order.ID)
+		receiver := common.HexToAddress(owner.WalletAddress)
+
+		response := OrderResponse{
+			ID:              order.ID.String(),
+			OwnerAddress:    owner.WalletAddress,
+			ChainID:         order.ChainID,
+			EscrowOrderID:   hexutil.Encode(escrowOrderId[:]),
+			ContractAddress: contractAddress.Hex(),
+			Value:           order.Total.String(),
+			ReleaseTime:     releaseTime.Unix(),
+		}
+
+		if order.Token == "" {
+			calldata, err := p.escrow.CreateOrderCalldata(order.ID, receiver, releaseTime)
+			if err != nil {
+				return nil, err
+			}
+
+			response.Calldata = hexutil.Encode(calldata)
+		} else {
+			// Token orders carry no value, the escrow pulls the total the buyer approved
+			token := common.HexToAddress(order.Token)
+
+			calldata, err := p.escrow.CreateTokenOrderCalldata(order.ID, receiver, token, order.Total.Big(), releaseTime)
+			if err != nil {
+				return nil, err
+			}
+
+			approveCalldata, err := p.escrow.ApproveCalldata(contractAddress, order.Total.Big())
+			if err != nil {
+				return nil, err
+			}
+
+			response.Value = "0"
+			response.Calldata = hexutil.Encode(calldata)
+			response.TokenAddress = token.Hex()
+			response.ApproveCalldata = hexutil.Encode(approveCalldata)
+		}
+
+		orderResponses = append(orderResponses, response)
 	}
 
 	return orderResponses, nil
@@ -166,6 +327,207 @@ func (p *productsService) GetOrder(id string) (*Orders, error) {
 	return &order, nil
 }
 
+func (p *productsService) GetOrderHistory(userId string, id string, viewAll bool) ([]OrderStatusHistory, error) {
+	db := p.db.DB()
+
+	order := Orders{}
+	result := db.Preload("Product.Store").Where("id = ?", id).First(&order)
+	if result.Error != nil {
+		return nil, errors.New("order not found")
+	}
+
+	// Who changed an order and when is only for its parties and admins
+	isBuyer := order.BuyerID.String() == userId
+	isSeller := order.Product.Store.OwnerID.String() == userId
+	if !isBuyer && !isSeller && !viewAll {
+		return nil, errors.New("unauthorized")
+	}
+
+	var history []OrderStatusHistory
+	result = db.Where("order_id = ?", order.ID).Order("created_at asc").Find(&history)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return history, nil
+}
+
+func (p *productsService) UpdateOrderStatus(userId string, id string, status OrderStatus) error {
+	order := Orders{}
+	result := p.db.DB().Preload("Product.Store").Where("id = ?", id).First(&order)
+	if result.Error != nil {
+		return errors.New("order not found")
+	}
+
+	isBuyer := order.BuyerID.String() == userId
+	isSeller := order.Product.Store.OwnerID.String() == userId
+
+	// Payment, completion and refunds are driven by the escrow, users only ship and cancel
+	switch {
+	case status == OrderStatusShipped && isSeller:
+	case status == OrderStatusCancelled && (isBuyer || isSeller):
+	default:
+		return errors.New("unauthorized")
+	}
+
+	return p.orderState.Transition(order.ID, db.OrderStatus(status), userId, orderstate.SourceAPI)
+}
+
+func (p *productsService) VerifyOrderTransaction(userId string, id string, txHash string) (*Orders, error) {
+	re := regexp.MustCompile("^0x[0-9a-fA-F]{64}$")
+	if !re.MatchString(txHash) {
+		return nil, errors.New("invalid transaction hash")
+	}
+
+	order := Orders{}
+	result := p.db.DB().Where("id = ?", id).First(&order)
+	if result.Error != nil {
+		return nil, errors.New("order not found")
+	}
+
+	if order.BuyerID.String() != userId {
+		return nil, errors.New("unauthorized")
+	}
+
+	if err := p.observer.VerifyTransaction(order.ChainID, order.ID.String(), common.HexToHash(txHash)); err != nil {
+		return nil, err
+	}
+
+	return p.GetOrder(id)
+}
+
+func (p *productsService) GetClaimableOrders(userId string) ([]Orders, error) {
+	var orders []Orders
+
+	result := p.claimable(userId).Preload("Product").Order("release_time asc").Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return orders, nil
+}
+
+func (p *productsService) ClaimOrders(userId string, orderIds []string) (*ClaimResponse, error) {
+	if len(orderIds) == 0 {
+		return nil, errors.New("no orders selected")
+	}
+
+	// Payouts go to the seller's wallet, so they are only built for accounts with a second factor
+	if err := p.requireTwoFactor(uuid.FromStringOrNil(userId)); err != nil {
+		return nil, err
+	}
+
+	for _, id := range orderIds {
+		if _, err := uuid.FromString(id); err != nil {
+			return nil, errors.New("some orders are not claimable")
+		}
+	}
+
+	var orders []Orders
+	result := p.claimable(userId).Where("orders.id IN ?", orderIds).Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(orders) != len(orderIds) {
+		return nil, errors.New("some orders are not claimable")
+	}
+
+	// A claim is a single transaction, so its orders must be on one network
+	chainId := orders[0].ChainID
+	ids := make([]uuid.UUID, len(orders))
+	claimed := make([]string, len(orders))
+	for i, order := range orders {
+		if order.ChainID != chainId {
+			return nil, errors.New("orders settle on different networks")
+		}
+
+		ids[i] = order.ID
+		claimed[i] = order.ID.String()
+	}
+
+	contractAddress, err := p.escrow.Address(chainId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.escrow.ClaimOrdersCalldata(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClaimResponse{
+		ChainID:  chainId,
+		To:       contractAddress.Hex(),
+		Data:     hexutil.Encode(data),
+		OrderIDs: claimed,
+	}, nil
+}
+
+func (p *productsService) PriceProducts(products []Products, currency string) error {
+	for i := range products {
+		price, err := p.pricing.Convert(products[i].Price, p.decimals(products[i].ChainID, products[i].Unit), products[i].Unit, currency)
+		if err != nil {
+			return err
+		}
+
+		products[i].DisplayPrice = price
+	}
+
+	return nil
+}
+
+func (p *productsService) PriceOrders(orders []Orders, currency string) error {
+	for i := range orders {
+		total, err := p.pricing.Convert(orders[i].Total, p.decimals(orders[i].ChainID, orders[i].Product.Unit), orders[i].Product.Unit, currency)
+		if err != nil {
+			return err
+		}
+
+		orders[i].DisplayTotal = total
+	}
+
+	return nil
+}
+
+// decimals returns the decimals of the currency amounts on a network are counted in,
+// a whitelisted token's or the native currency's
+func (p *productsService) decimals(chainId uint64, unit string) int {
+	token, err := p.escrow.Token(chainId, unit)
+	if err != nil {
+		return money.EtherDecimals
+	}
+
+	return token.Decimals
+}
+
+// claimable scopes orders to the seller's funded orders past their release time without an open dispute
+func (p *productsService) claimable(userId string) *gorm.DB {
+	return p.db.DB().Model(&Orders{}).
+		Where("orders.product_id IN (SELECT id FROM products WHERE store_id IN (SELECT id FROM stores WHERE owner_id = ?))", userId).
+		Where("orders.status IN ?", []OrderStatus{OrderStatusPaid, OrderStatusShipped}).
+		Where("orders.release_time <= ?", time.Now()).
+		Where("NOT EXISTS (SELECT 1 FROM disputes WHERE disputes.order_id = orders.id AND disputes.resolved = false AND disputes.deleted_at IS NULL)")
+}
+
+// requireTwoFactor rejects users without two-factor authentication when it is mandatory for sellers
+func (p *productsService) requireTwoFactor(userId uuid.UUID) error {
+	if !p.cfg.GetTwoFactor().RequiredForSellers {
+		return nil
+	}
+
+	user := Users{}
+	result := p.db.DB().Where("id = ?", userId).First(&user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if user.TwoFactorEnabledAt == nil {
+		return ErrTwoFactorRequired
+	}
+
+	return nil
+}
+
 func (p *productsService) loads() []Products {
 	var products []Products
 	p.db.DB().Joins("Store").Find(&products)
@@ -197,6 +559,34 @@ func (p *productsService) save(userId uuid.UUID, product *Products) (string, err
 		return "", errors.New("unauthorized")
 	}
 
+	if err := p.requireTwoFactor(userId); err != nil {
+		return "", err
+	}
+
+	network := p.escrow.DefaultNetwork()
+	if product.ChainID != 0 {
+		var err error
+		network, err = p.escrow.Network(product.ChainID)
+		if err != nil {
+			return "", err
+		}
+	}
+	product.ChainID = network.ChainID
+
+	// Anything other than the native currency must be a token whitelisted on the network
+	if product.Unit == "" || strings.EqualFold(product.Unit, network.Currency) {
+		product.Unit = network.Currency
+		product.Token = ""
+	} else {
+		token, err := p.escrow.Token(network.ChainID, product.Unit)
+		if err != nil {
+			return "", err
+		}
+
+		product.Unit = token.Symbol
+		product.Token = common.HexToAddress(token.Address).Hex()
+	}
+
 	result = db.Create(&product)
 	if result.Error != nil {
 		return "", result.Error
@@ -214,7 +604,8 @@ func (p *productsService) update(userId uuid.UUID, id string, product *Products)
 		return errors.New("unauthorized")
 	}
 
-	result := db.Model(&product).Omit("store_id").Where("id = ?", id).Updates(product)
+	// The network and its currency are fixed once a product is listed
+	result := db.Model(&product).Omit("store_id", "chain_id", "unit", "token").Where("id = ?", id).Updates(product)
 	if result.Error != nil {
 		return result.Error
 	}
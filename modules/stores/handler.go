@@ -2,6 +2,7 @@ package stores
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -55,6 +56,10 @@ func (s *storesHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := s.svc.CreateStore(userId, store); err != nil {
+		if errors.Is(err, ErrTwoFactorRequired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
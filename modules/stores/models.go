@@ -1,20 +1,25 @@
 package stores
 
 import (
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
 
 type Users struct {
 	gorm.Model
-	ID            uuid.UUID `gorm:"primaryKey"`
-	FirstName     string    `gorm:"not null"`
-	LastName      string    `gorm:"not null"`
-	Address       string
-	Email         string `gorm:"not null, unique"`
-	EmailVerified bool   `gorm:"default:false"`
-	Password      string `gorm:"not null"`
-	WalletAddress string
+	ID                 uuid.UUID `gorm:"primaryKey"`
+	FirstName          string    `gorm:"not null"`
+	LastName           string    `gorm:"not null"`
+	Address            string
+	Email              string `gorm:"not null, unique"`
+	EmailVerified      bool   `gorm:"default:false"`
+	Password           string `gorm:"not null"`
+	WalletAddress      string
+	WalletVerifiedAt   *time.Time
+	TwoFactorEnabledAt *time.Time
 }
 
 type Stores struct {
@@ -28,15 +33,17 @@ type Stores struct {
 
 type Products struct {
 	gorm.Model
-	ID       uuid.UUID `gorm:"primaryKey"`
-	Name     string    `gorm:"not null"`
-	ImageURL string    `gorm:"not null"`
-	Price    float64   `gorm:"not null"`
-	Unit     string    `gorm:"not null"`
+	ID       uuid.UUID    `gorm:"primaryKey"`
+	Name     string       `gorm:"not null"`
+	ImageURL string       `gorm:"not null"`
+	Price    money.Amount `gorm:"not null"`
+	Unit     string       `gorm:"not null"`
 	// TODO: Define options for products
 	Description string    `gorm:"not null"`
 	StoreID     uuid.UUID `gorm:"not null"`
 	Store       Stores    `gorm:"foreignKey:StoreID"`
+	ChainID     uint64    `gorm:"not null;default:0"`
+	Token       string
 }
 
 func (s *Stores) BeforeCreate(tx *gorm.DB) (err error) {
@@ -3,17 +3,24 @@ package stores
 import (
 	"errors"
 
+	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
 	"github.com/gofrs/uuid/v5"
 	"github.com/samber/do"
 )
 
+// ErrTwoFactorRequired is returned when two-factor authentication is mandatory for sellers
+// and the user has not enabled it
+var ErrTwoFactorRequired = errors.New("two-factor authentication required to open a store")
+
 // NewStoresService creates a new users service
 func NewStoresService(i *do.Injector) (Service, error) {
 	db := do.MustInvoke[db.DB](i)
+	cfg := do.MustInvoke[config.Config](i)
 
 	return &storesService{
-		db: db,
+		db:  db,
+		cfg: cfg,
 	}, nil
 }
 
@@ -86,6 +93,13 @@ func (s *storesService) save(userId uuid.UUID, store *Stores) error {
 	if user.WalletAddress == "" {
 		return errors.New("user has not set wallet address")
 	}
+	// Escrow deposits for the store are paid out to the owner's wallet, so it has to be proven theirs
+	if user.WalletVerifiedAt == nil {
+		return errors.New("user has not verified wallet address")
+	}
+	if s.cfg.GetTwoFactor().RequiredForSellers && user.TwoFactorEnabledAt == nil {
+		return ErrTwoFactorRequired
+	}
 
 	existingStore := Stores{}
 	result := db.Where("name = ?", store.Name).First(&existingStore)
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
 	"github.com/TechXTT/bazaar-backend/services/middleware"
 	"github.com/TechXTT/bazaar-backend/services/web"
@@ -59,7 +60,8 @@ type (
 	}
 
 	storesService struct {
-		db db.DB
+		db  db.DB
+		cfg config.Config
 	}
 
 	storesHandler struct {
@@ -2,8 +2,14 @@ package users
 
 import (
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
+	"github.com/TechXTT/bazaar-backend/services/sessions"
+	"github.com/gorilla/mux"
 	"github.com/samber/do"
 )
 
@@ -22,6 +28,10 @@ func (u *usersHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := u.svc.CreateUser(user); err != nil {
+		if errors.Is(err, mailer.ErrInvalidRecipient) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -40,6 +50,10 @@ func (u *usersHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := u.svc.UpdateUser(user_id, user); err != nil {
+		if errors.Is(err, ErrWalletNotVerified) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -83,14 +97,163 @@ func (u *usersHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := u.svc.LoginUser(creds.Email, creds.Password)
+	result, err := u.svc.LoginUser(creds.Email, creds.Password, client(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	json.NewEncoder(w).Encode(result)
+}
+
+func (u *usersHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	req := &TwoFactorLoginRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := u.svc.LoginTwoFactor(req.MFAToken, req.Code, client(r))
+	if err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (u *usersHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	req := &ForgotPasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.svc.ForgotPassword(req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The response is the same whether or not the email is registered
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (u *usersHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	req := &ResetPasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.svc.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, ErrInvalidResetToken) || errors.Is(err, ErrInvalidPassword) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *usersHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	req := &RefreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := u.svc.RefreshSession(req.RefreshToken, client(r))
+	if err != nil {
+		if errors.Is(err, sessions.ErrInvalidRefreshToken) || errors.Is(err, sessions.ErrSessionRevoked) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (u *usersHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+	session_id := r.Header.Get("session_id")
+
+	if err := u.svc.Logout(user_id, session_id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *usersHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+	session_id := r.Header.Get("session_id")
+
+	list, err := u.svc.GetSessions(user_id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range list {
+		list[i].Current = list[i].ID == session_id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (u *usersHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+	vars := mux.Vars(r)
+
+	if err := u.svc.Logout(user_id, vars["id"]); err != nil {
+		if errors.Is(err, sessions.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (u *usersHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+	vars := mux.Vars(r)
+
+	request := RoleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.svc.SetRole(user_id, vars["id"], request.Role); err != nil {
+		switch {
+		case errors.Is(err, rbac.ErrUnknownRole), errors.Is(err, ErrOwnRole):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrUserNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 }
 
 func (u *usersHandler) Verify(w http.ResponseWriter, r *http.Request) {
@@ -105,3 +268,164 @@ func (u *usersHandler) Verify(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "http://localhost:3000", http.StatusSeeOther)
 }
+
+func (u *usersHandler) SIWENonce(w http.ResponseWriter, r *http.Request) {
+	nonce, err := u.svc.SIWENonce()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"nonce": nonce})
+}
+
+func (u *usersHandler) SIWEVerify(w http.ResponseWriter, r *http.Request) {
+	req := &SIWERequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := u.svc.LoginWithEthereum(req.Message, req.Signature, client(r))
+	if err != nil {
+		if errors.Is(err, ErrSIWERejected) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (u *usersHandler) WalletChallenge(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+
+	req := &WalletChallengeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message, err := u.svc.WalletChallenge(user_id, req.Address)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAddress) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func (u *usersHandler) WalletVerify(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+
+	req := &WalletVerifyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := u.svc.VerifyWallet(user_id, req.Signature)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrWalletNotVerified):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrWalletInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// client describes where a request to start or refresh a session comes from
+func client(r *http.Request) sessions.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return sessions.Client{UserAgent: r.UserAgent(), IPAddress: ip}
+}
+
+func (u *usersHandler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+
+	enrollment, err := u.svc.EnrollTwoFactor(user_id)
+	if err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (u *usersHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+
+	req := &TwoFactorCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := u.svc.ConfirmTwoFactor(user_id, req.Code)
+	if err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+func (u *usersHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user_id := r.Header.Get("user_id")
+
+	req := &TwoFactorCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := u.svc.DisableTwoFactor(user_id, req.Code); err != nil {
+		twoFactorError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// twoFactorError writes the response for a failed two-factor authentication request
+func twoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidMFAToken), errors.Is(err, ErrInvalidTwoFactorCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrTwoFactorLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrTwoFactorEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrTwoFactorNotEnrolled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTwoFactorRequired):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,20 +1,63 @@
 package users
 
 import (
+	"time"
+
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
 
 type Users struct {
 	gorm.Model
-	ID            uuid.UUID `gorm:"primaryKey"`
-	FirstName     string    `gorm:"not null"`
-	LastName      string    `gorm:"not null"`
-	Address       string
-	Email         string `gorm:"not null, unique"`
-	EmailVerified bool   `gorm:"default:false"`
-	Password      string `gorm:"not null"`
-	WalletAddress string
+	ID                 uuid.UUID `gorm:"primaryKey"`
+	FirstName          string    `gorm:"not null"`
+	LastName           string    `gorm:"not null"`
+	Address            string
+	Email              string `gorm:"not null, unique"`
+	EmailVerified      bool   `gorm:"default:false"`
+	Password           string `gorm:"not null"`
+	WalletAddress      string
+	WalletVerifiedAt   *time.Time
+	Role               string `gorm:"not null;default:user"`
+	TwoFactorEnabledAt *time.Time
+}
+
+type SiweNonces struct {
+	gorm.Model
+	Nonce     string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+type WalletChallenges struct {
+	gorm.Model
+	UserID        uuid.UUID `gorm:"not null;index"`
+	WalletAddress string    `gorm:"not null"`
+	Message       string    `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+}
+
+type PasswordResets struct {
+	gorm.Model
+	UserID    uuid.UUID `gorm:"not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+type TwoFactors struct {
+	gorm.Model
+	UserID         uuid.UUID `gorm:"not null;uniqueIndex"`
+	Secret         string    `gorm:"not null"`
+	LastStep       int64     `gorm:"not null;default:0"`
+	FailedAttempts int       `gorm:"not null;default:0"`
+	LockedUntil    *time.Time
+}
+
+type RecoveryCodes struct {
+	gorm.Model
+	UserID   uuid.UUID `gorm:"not null;index"`
+	CodeHash string    `gorm:"not null;index"`
+	UsedAt   *time.Time
 }
 
 func (u *Users) BeforeCreate(tx *gorm.DB) (err error) {
@@ -0,0 +1,221 @@
+// Package siwe parses and verifies Sign-In with Ethereum (EIP-4361) messages
+package siwe
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const header = " wants you to sign in with your Ethereum account:"
+
+var (
+	ErrInvalidMessage   = errors.New("invalid sign-in message")
+	ErrInvalidSignature = errors.New("invalid sign-in signature")
+	ErrExpired          = errors.New("sign-in message expired")
+	ErrNotYetValid      = errors.New("sign-in message not yet valid")
+)
+
+// Message is a parsed EIP-4361 message
+type Message struct {
+	Scheme    string
+	Domain    string
+	Address   common.Address
+	Statement string
+	URI       string
+	Version   string
+	ChainID   uint64
+	Nonce     string
+	IssuedAt  time.Time
+
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// NewNonce returns a random alphanumeric nonce
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Parse reads a message in the EIP-4361 format, the optional statement may be
+// separated from the address and fields by one or more blank lines
+func Parse(message string) (*Message, error) {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	if len(lines) < 3 || !strings.HasSuffix(lines[0], header) {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidMessage)
+	}
+
+	m := &Message{Domain: strings.TrimSuffix(lines[0], header)}
+	if scheme, domain, ok := strings.Cut(m.Domain, "://"); ok {
+		m.Scheme, m.Domain = scheme, domain
+	}
+	if m.Domain == "" {
+		return nil, fmt.Errorf("%w: missing domain", ErrInvalidMessage)
+	}
+
+	// Addresses must be checksummed, so a mistyped one is not silently accepted
+	if !common.IsHexAddress(lines[1]) || common.HexToAddress(lines[1]).Hex() != lines[1] {
+		return nil, fmt.Errorf("%w: address is not EIP-55 checksummed", ErrInvalidMessage)
+	}
+	m.Address = common.HexToAddress(lines[1])
+
+	i := skipBlank(lines, 2)
+	if i < len(lines) && !strings.HasPrefix(lines[i], "URI: ") {
+		m.Statement = lines[i]
+		i = skipBlank(lines, i+1)
+	}
+
+	fields := map[string]string{}
+	for ; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		if lines[i] == "Resources:" {
+			for i++; i < len(lines) && strings.HasPrefix(lines[i], "- "); i++ {
+				m.Resources = append(m.Resources, strings.TrimPrefix(lines[i], "- "))
+			}
+			i--
+			continue
+		}
+
+		key, value, ok := strings.Cut(lines[i], ": ")
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected line %q", ErrInvalidMessage, lines[i])
+		}
+		if _, seen := fields[key]; seen {
+			return nil, fmt.Errorf("%w: duplicate %s", ErrInvalidMessage, key)
+		}
+		fields[key] = value
+	}
+
+	for _, key := range []string{"URI", "Version", "Chain ID", "Nonce", "Issued At"} {
+		if fields[key] == "" {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidMessage, key)
+		}
+	}
+
+	m.URI = fields["URI"]
+	m.Version = fields["Version"]
+	if m.Version != "1" {
+		return nil, fmt.Errorf("%w: unsupported version %s", ErrInvalidMessage, m.Version)
+	}
+
+	var err error
+	m.ChainID, err = strconv.ParseUint(fields["Chain ID"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid chain id", ErrInvalidMessage)
+	}
+
+	m.Nonce = fields["Nonce"]
+	if len(m.Nonce) < 8 || strings.IndexFunc(m.Nonce, func(r rune) bool { return !isAlphanumeric(r) }) >= 0 {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrInvalidMessage)
+	}
+
+	m.IssuedAt, err = time.Parse(time.RFC3339, fields["Issued At"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid issued at", ErrInvalidMessage)
+	}
+
+	m.ExpirationTime, err = optionalTime(fields, "Expiration Time")
+	if err != nil {
+		return nil, err
+	}
+
+	m.NotBefore, err = optionalTime(fields, "Not Before")
+	if err != nil {
+		return nil, err
+	}
+
+	m.RequestID = fields["Request ID"]
+
+	return m, nil
+}
+
+// Valid checks the message is within its validity period
+func (m *Message) Valid(now time.Time) error {
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return ErrExpired
+	}
+	if m.NotBefore != nil && now.Before(*m.NotBefore) {
+		return ErrNotYetValid
+	}
+
+	return nil
+}
+
+// Verify checks a personal_sign signature of the raw message was made by the message's address
+func (m *Message) Verify(message string, signature []byte) error {
+	signer, err := RecoverAddress(message, signature)
+	if err != nil {
+		return err
+	}
+
+	if signer != m.Address {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// RecoverAddress returns the account that signed a message with personal_sign (EIP-191)
+func RecoverAddress(message string, signature []byte) (common.Address, error) {
+	if len(signature) != crypto.SignatureLength {
+		return common.Address{}, ErrInvalidSignature
+	}
+
+	// Wallets return the recovery id as 27 or 28, go-ethereum expects 0 or 1
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig, signature)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	publicKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return common.Address{}, ErrInvalidSignature
+	}
+
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
+
+func optionalTime(fields map[string]string, key string) (*time.Time, error) {
+	value, ok := fields[key]
+	if !ok {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s", ErrInvalidMessage, strings.ToLower(key))
+	}
+
+	return &t, nil
+}
+
+func skipBlank(lines []string, i int) int {
+	for i < len(lines) && lines[i] == "" {
+		i++
+	}
+
+	return i
+}
+
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
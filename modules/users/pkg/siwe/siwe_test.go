@@ -0,0 +1,115 @@
+package siwe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const message = `localhost:3000 wants you to sign in with your Ethereum account:
+{{address}}
+
+Sign in to Bazaar
+
+URI: http://localhost:3000
+Version: 1
+Chain ID: 11155111
+Nonce: 32891756fa2c4b1e
+Issued At: 2026-10-18T12:00:00Z
+Expiration Time: 2026-10-18T12:10:00Z
+Resources:
+- https://bazaar.example/terms`
+
+func signedMessage(t *testing.T) (string, []byte) {
+	t.Helper()
+
+	key, _ := crypto.GenerateKey()
+	msg := strings.Replace(message, "{{address}}", crypto.PubkeyToAddress(key.PublicKey).Hex(), 1)
+
+	signature, err := crypto.Sign(accounts.TextHash([]byte(msg)), key)
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	// Wallets return the recovery id as 27 or 28
+	signature[crypto.RecoveryIDOffset] += 27
+
+	return msg, signature
+}
+
+func TestParseAndVerify(t *testing.T) {
+	msg, signature := signedMessage(t)
+
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+
+	if m.Domain != "localhost:3000" || m.Statement != "Sign in to Bazaar" || m.ChainID != 11155111 || m.Nonce != "32891756fa2c4b1e" {
+		t.Errorf("message = %+v", m)
+	}
+	if len(m.Resources) != 1 || m.ExpirationTime == nil {
+		t.Errorf("resources = %v, expiration = %v", m.Resources, m.ExpirationTime)
+	}
+
+	err = m.Verify(msg, signature)
+	if err != nil {
+		t.Errorf("verifying: %v", err)
+	}
+
+	tampered := strings.Replace(msg, "Chain ID: 11155111", "Chain ID: 1", 1)
+	err = m.Verify(tampered, signature)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verifying tampered message = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestParseWithoutStatement(t *testing.T) {
+	msg, _ := signedMessage(t)
+	msg = strings.Replace(msg, "Sign in to Bazaar\n", "", 1)
+
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if m.Statement != "" || m.URI != "http://localhost:3000" {
+		t.Errorf("statement = %q, uri = %q", m.Statement, m.URI)
+	}
+}
+
+func TestParseRejectsInvalidMessages(t *testing.T) {
+	msg, _ := signedMessage(t)
+	address := strings.Split(msg, "\n")[1]
+
+	for name, invalid := range map[string]string{
+		"lowercase address": strings.Replace(msg, address, strings.ToLower(address), 1),
+		"missing nonce":     strings.Replace(msg, "Nonce: 32891756fa2c4b1e\n", "", 1),
+		"short nonce":       strings.Replace(msg, "32891756fa2c4b1e", "abc", 1),
+		"version":           strings.Replace(msg, "Version: 1", "Version: 2", 1),
+		"header":            strings.Replace(msg, "wants you to sign in", "wants you to log in", 1),
+	} {
+		_, err := Parse(invalid)
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrInvalidMessage)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	msg, _ := signedMessage(t)
+
+	m, err := Parse(msg)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+
+	if err := m.Valid(m.IssuedAt); err != nil {
+		t.Errorf("valid at issue time: %v", err)
+	}
+	if err := m.Valid(m.ExpirationTime.Add(time.Second)); !errors.Is(err, ErrExpired) {
+		t.Errorf("valid after expiration = %v, want %v", err, ErrExpired)
+	}
+}
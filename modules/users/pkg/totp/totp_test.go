@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B
+func TestCodeMatchesRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		if got := code(key, Step(time.Unix(unix, 0)), 8); got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1234567890, 0)
+
+	passcode, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatalf("generating code: %v", err)
+	}
+	if passcode != "005924" {
+		t.Fatalf("code = %s, want the last digits of the RFC vector", passcode)
+	}
+
+	step, ok := Validate(secret, passcode, now.Add(Period))
+	if !ok || step != Step(now) {
+		t.Errorf("validating a code of the previous period = %d, %v", step, ok)
+	}
+
+	_, ok = Validate(secret, passcode, now.Add(3*Period))
+	if ok {
+		t.Error("validated a code three periods old")
+	}
+	_, ok = Validate(secret, "12345", now)
+	if ok {
+		t.Error("validated a code of the wrong length")
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generating secret: %v", err)
+	}
+
+	u, err := url.Parse(URI("Bazaar", "ada@example.com", secret))
+	if err != nil {
+		t.Fatalf("parsing uri: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Bazaar:ada@example.com" {
+		t.Errorf("uri = %s", u)
+	}
+	if u.Query().Get("secret") != secret || u.Query().Get("issuer") != "Bazaar" {
+		t.Errorf("query = %s", u.RawQuery)
+	}
+}
@@ -2,31 +2,85 @@ package users
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"regexp"
+	"strings"
+	"time"
 
-	"github.com/TechXTT/bazaar-backend/modules/users/pkg/email"
 	"github.com/TechXTT/bazaar-backend/modules/users/pkg/passwords"
+	"github.com/TechXTT/bazaar-backend/modules/users/pkg/siwe"
+	"github.com/TechXTT/bazaar-backend/modules/users/pkg/totp"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
 	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
 	"github.com/TechXTT/bazaar-backend/services/jwt"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
+	"github.com/TechXTT/bazaar-backend/services/sessions"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gofrs/uuid/v5"
 	"github.com/samber/do"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFrontendURL  = "http://localhost:3000"
+	defaultSIWEDomain   = "localhost:3000"
+	defaultSIWENonceTTL = 10 * time.Minute
+
+	defaultTOTPIssuer = "Bazaar"
+	// recoveryCodeCount codes are issued when two-factor authentication is enabled
+	recoveryCodeCount = 10
+	// maxTwoFactorAttempts wrong codes in a row lock the second factor for twoFactorLockout,
+	// as six digits would otherwise be guessed quickly
+	maxTwoFactorAttempts = 5
+	twoFactorLockout     = 15 * time.Minute
+)
+
+var (
+	// ErrSIWERejected is returned for a Sign-In with Ethereum message that does not log anyone in
+	ErrSIWERejected = errors.New("sign-in with ethereum rejected")
+
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrWalletNotVerified = errors.New("wallet ownership not verified")
+	ErrWalletInUse       = errors.New("wallet already linked to another user")
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	ErrInvalidPassword   = errors.New("password is required")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrOwnRole           = errors.New("cannot change your own role")
+
+	ErrInvalidMFAToken      = errors.New("invalid or expired mfa token")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrTwoFactorLocked      = errors.New("too many invalid two-factor codes, try again later")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication not enrolled")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for store owners")
 )
 
 // NewUsersService creates a new users service
 func NewUsersService(i *do.Injector) (Service, error) {
 	db := do.MustInvoke[db.DB](i)
 	jwks := do.MustInvoke[jwt.Jwks](i)
+	sessions := do.MustInvoke[sessions.Sessions](i)
+	mailer := do.MustInvoke[mailer.Mailer](i)
 	cfg := do.MustInvoke[config.Config](i)
+	events := do.MustInvoke[events.Bus](i)
 
 	return &usersService{
-		db:   db,
-		jwks: jwks,
-		cfg:  cfg,
+		db:       db,
+		jwks:     jwks,
+		sessions: sessions,
+		mailer:   mailer,
+		cfg:      cfg,
+		events:   events,
 	}, nil
 }
 
@@ -41,9 +95,11 @@ func (u *usersService) CreateUser(user *Users) error {
 
 func (u *usersService) UpdateUser(id string, user *Users) error {
 
+	// The wallet is only changed by signing a challenge, see WalletChallenge
 	if user.WalletAddress != "" {
-		if err := u.validateAddress(user.WalletAddress); err != nil {
-			return err
+		current := u.load(uuid.FromStringOrNil(id))
+		if !strings.EqualFold(current.WalletAddress, user.WalletAddress) {
+			return fmt.Errorf("%w: request a wallet challenge to change the wallet address", ErrWalletNotVerified)
 		}
 	}
 
@@ -64,7 +120,7 @@ func (u *usersService) DeleteUser(id string) error {
 		return err
 	}
 
-	return nil
+	return u.sessions.RevokeAll(id)
 }
 
 func (u *usersService) GetMe(id string) (*Users, error) {
@@ -77,28 +133,391 @@ func (u *usersService) GetMe(id string) (*Users, error) {
 	return nil, errors.New("user not found")
 }
 
-func (u *usersService) LoginUser(email string, password string) (string, error) {
+func (u *usersService) LoginUser(email string, password string, client sessions.Client) (*LoginResult, error) {
 	user := u.loadByEmail(email)
 
 	if user.ID != uuid.Nil {
 		err := passwords.ComparePassword(user.Password, password)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		token, err := u.jwks.GenerateToken(user.ID.String())
+		return u.login(user, client)
+	}
+
+	return nil, errors.New("user not found")
+}
+
+// login starts a session for a user who proved their first factor, or with two-factor
+// authentication enabled returns the MFA token LoginTwoFactor completes the login with
+func (u *usersService) login(user Users, client sessions.Client) (*LoginResult, error) {
+	if user.TwoFactorEnabledAt == nil {
+		tokens, err := u.sessions.Create(user.ID, client)
 		if err != nil {
-			return "", err
+			return nil, err
+		}
+
+		return &LoginResult{Tokens: tokens}, nil
+	}
+
+	token, err := u.jwks.GenerateToken(user.ID.String(), jwt.PurposeMFA)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{MFARequired: true, MFAToken: token}, nil
+}
+
+func (u *usersService) LoginTwoFactor(mfaToken string, code string, client sessions.Client) (*sessions.Tokens, error) {
+	id, err := u.jwks.ValidateToken(mfaToken, jwt.PurposeMFA)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMFAToken, err)
+	}
+
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil || user.TwoFactorEnabledAt == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	err = u.verifySecondFactor(user.ID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.sessions.Create(user.ID, client)
+}
+
+func (u *usersService) EnrollTwoFactor(id string) (*TwoFactorEnrollment, error) {
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil {
+		return nil, ErrUserNotFound
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// Enrolling again replaces a secret that was never confirmed
+	err = u.db.DB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&TwoFactors{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Create(&TwoFactors{UserID: user.ID, Secret: secret}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Wallet users have no email, their wallet names the account instead
+	account := user.Email
+	if account == "" {
+		account = user.WalletAddress
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(u.totpIssuer(), account, secret),
+	}, nil
+}
+
+func (u *usersService) ConfirmTwoFactor(id string, code string) ([]string, error) {
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil {
+		return nil, ErrUserNotFound
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	var twoFactor TwoFactors
+	result := u.db.DB().Where("user_id = ?", user.ID).Limit(1).Find(&twoFactor)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	// Only a code of the new secret proves the authenticator app was set up
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.db.DB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCodes{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		for _, code := range codes {
+			err := tx.Create(&RecoveryCodes{UserID: user.ID, CodeHash: hashToken(code)}).Error
+			if err != nil {
+				return err
+			}
 		}
 
-		return token, nil
+		result = tx.Model(&TwoFactors{}).Where("id = ?", twoFactor.ID).Update("last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Model(&Users{}).Where("id = ?", user.ID).Update("two_factor_enabled_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (u *usersService) DisableTwoFactor(id string, code string) error {
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil {
+		return ErrUserNotFound
+	}
+	if user.TwoFactorEnabledAt == nil {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	db := u.db.DB()
+
+	if u.cfg.GetTwoFactor().RequiredForSellers {
+		var stores int64
+		result := db.Table("stores").Where("owner_id = ? AND deleted_at IS NULL", user.ID).Count(&stores)
+		if result.Error != nil {
+			return result.Error
+		}
+		if stores > 0 {
+			return ErrTwoFactorRequired
+		}
+	}
+
+	err := u.verifySecondFactor(user.ID, code)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCodes{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Unscoped().Where("user_id = ?", user.ID).Delete(&TwoFactors{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Model(&Users{}).Where("id = ?", user.ID).Update("two_factor_enabled_at", nil).Error
+	})
+}
+
+// verifySecondFactor checks a TOTP or recovery code of a user with two-factor authentication
+// enabled, wrong codes count towards locking the second factor
+func (u *usersService) verifySecondFactor(userID uuid.UUID, code string) error {
+	db := u.db.DB()
+
+	var twoFactor TwoFactors
+	result := db.Where("user_id = ?", userID).Limit(1).Find(&twoFactor)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	now := time.Now()
+	if twoFactor.LockedUntil != nil && twoFactor.LockedUntil.After(now) {
+		return ErrTwoFactorLocked
+	}
+
+	code = strings.TrimSpace(code)
+
+	// Matching on the last step makes a code usable once, even by concurrent logins
+	if step, ok := totp.Validate(twoFactor.Secret, code, now); ok {
+		result = db.Model(&TwoFactors{}).
+			Where("id = ? AND last_step < ?", twoFactor.ID, step).
+			Updates(map[string]interface{}{"last_step": step, "failed_attempts": 0, "locked_until": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil
+		}
+	} else {
+		result = db.Model(&RecoveryCodes{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return db.Model(&TwoFactors{}).Where("id = ?", twoFactor.ID).
+				Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error
+		}
+	}
+
+	updates := map[string]interface{}{"failed_attempts": gorm.Expr("failed_attempts + 1")}
+	if twoFactor.FailedAttempts+1 >= maxTwoFactorAttempts {
+		updates = map[string]interface{}{"failed_attempts": 0, "locked_until": now.Add(twoFactorLockout)}
+	}
+
+	result = db.Model(&TwoFactors{}).Where("id = ?", twoFactor.ID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+func (u *usersService) totpIssuer() string {
+	if issuer := u.cfg.GetTwoFactor().Issuer; issuer != "" {
+		return issuer
+	}
+
+	return defaultTOTPIssuer
+}
+
+// frontendURL is the base of the links to the frontend sent in mails
+func (u *usersService) frontendURL() string {
+	if url := u.cfg.GetApp().FrontendURL; url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+
+	return defaultFrontendURL
+}
+
+func (u *usersService) ForgotPassword(emailAddress string) error {
+	// Wallet users have no email, an empty one must not match them
+	if emailAddress == "" {
+		return nil
+	}
+
+	user := u.loadByEmail(emailAddress)
+	if user.ID == uuid.Nil {
+		return nil
+	}
+
+	token, err := u.jwks.GenerateToken(user.ID.String(), jwt.PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(jwt.Lifetime(jwt.PurposePasswordReset))
+
+	// Only the latest reset link of a user works
+	err = u.db.DB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&PasswordResets{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		err := tx.Create(&PasswordResets{
+			UserID:    user.ID,
+			TokenHash: hashToken(token),
+			ExpiresAt: expiresAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// Queued rather than sent, so the response time does not tell registered emails apart
+		return u.mailer.Queue(tx, mailer.Mail{
+			To:       user.Email,
+			Template: mailer.TemplatePasswordReset,
+			Data: map[string]string{
+				"UserName":  user.FirstName,
+				"ResetLink": fmt.Sprintf("%s/reset-password?token=%s", u.frontendURL(), token),
+			},
+			ExpiresAt: expiresAt,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	u.mailer.Notify()
+
+	return nil
+}
+
+func (u *usersService) ResetPassword(token string, password string) error {
+	if password == "" {
+		return ErrInvalidPassword
+	}
+
+	id, err := u.jwks.ValidateToken(token, jwt.PurposePasswordReset)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResetToken, err)
+	}
+
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := passwords.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = u.db.DB().Transaction(func(tx *gorm.DB) error {
+		// Marking the token used only if it was not makes it single-use under concurrent requests
+		result := tx.Model(&PasswordResets{}).
+			Where("token_hash = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), user.ID, time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		err := tx.Model(&Users{}).Where("id = ?", user.ID).Update("password", hashedPassword).Error
+		if err != nil {
+			return err
+		}
+
+		return u.mailer.Queue(tx, mailer.Mail{
+			To:       user.Email,
+			Template: mailer.TemplatePasswordChanged,
+			Data:     map[string]string{"UserName": user.FirstName},
+		})
+	})
+	if err != nil {
+		return err
 	}
+	u.mailer.Notify()
 
-	return "", errors.New("user not found")
+	// Whoever knew the old password may still be logged in
+	return u.sessions.RevokeAll(user.ID.String())
+}
+
+func (u *usersService) RefreshSession(refreshToken string, client sessions.Client) (*sessions.Tokens, error) {
+	return u.sessions.Refresh(refreshToken, client)
+}
+
+func (u *usersService) Logout(id string, sessionID string) error {
+	return u.sessions.Revoke(id, sessionID)
+}
+
+func (u *usersService) GetSessions(id string) ([]sessions.Session, error) {
+	return u.sessions.List(id)
 }
 
 func (u *usersService) VerifyUser(token string) error {
-	id, err := u.jwks.ValidateToken(token)
+	id, err := u.jwks.ValidateToken(token, jwt.PurposeEmailVerify)
 	if err != nil {
 		return err
 	}
@@ -118,6 +537,250 @@ func (u *usersService) VerifyUser(token string) error {
 	return errors.New("user not found")
 }
 
+func (u *usersService) SIWENonce() (string, error) {
+	db := u.db.DB()
+
+	nonce, err := siwe.NewNonce()
+	if err != nil {
+		return "", err
+	}
+
+	ttl := u.nonceTTL()
+
+	// Expired nonces can never be used, so they are dropped as new ones are issued
+	db.Unscoped().Where("expires_at <= ?", time.Now()).Delete(&SiweNonces{})
+
+	result := db.Create(&SiweNonces{Nonce: nonce, ExpiresAt: time.Now().Add(ttl)})
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	return nonce, nil
+}
+
+func (u *usersService) LoginWithEthereum(message string, signature string, client sessions.Client) (*LoginResult, error) {
+	m, err := siwe.Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSIWERejected, err)
+	}
+
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSIWERejected, siwe.ErrInvalidSignature)
+	}
+
+	err = m.Verify(message, sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSIWERejected, err)
+	}
+
+	err = u.validateSIWE(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSIWERejected, err)
+	}
+
+	// The nonce is consumed last, so a rejected message does not burn it
+	result := u.db.DB().Unscoped().Where("nonce = ? AND expires_at > ?", m.Nonce, time.Now()).Delete(&SiweNonces{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: unknown, used or expired nonce", ErrSIWERejected)
+	}
+
+	user, err := u.loadOrCreateByWallet(m.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.login(user, client)
+}
+
+func (u *usersService) WalletChallenge(id string, address string) (string, error) {
+	db := u.db.DB()
+
+	err := u.validateAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	user := u.load(uuid.FromStringOrNil(id))
+	if user.ID == uuid.Nil {
+		return "", errors.New("user not found")
+	}
+
+	nonce, err := siwe.NewNonce()
+	if err != nil {
+		return "", err
+	}
+
+	domain := u.cfg.GetSIWE().Domain
+	if domain == "" {
+		domain = defaultSIWEDomain
+	}
+
+	// The message names the account and wallet, so a signature cannot link the wallet to anyone else
+	wallet := common.HexToAddress(address).Hex()
+	expiresAt := time.Now().Add(u.nonceTTL()).UTC().Truncate(time.Second)
+	message := fmt.Sprintf("%s wants you to link this wallet to your Bazaar account.\n\nAccount: %s\nWallet: %s\nNonce: %s\nExpiration Time: %s",
+		domain, user.ID, wallet, nonce, expiresAt.Format(time.RFC3339))
+
+	// Only the latest challenge of a user can be signed
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&WalletChallenges{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&WalletChallenges{
+			UserID:        user.ID,
+			WalletAddress: wallet,
+			Message:       message,
+			ExpiresAt:     expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return message, nil
+}
+
+func (u *usersService) VerifyWallet(id string, signature string) error {
+	db := u.db.DB()
+	userID := uuid.FromStringOrNil(id)
+
+	var challenge WalletChallenges
+	result := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("created_at desc").Limit(1).Find(&challenge)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: no pending wallet challenge", ErrWalletNotVerified)
+	}
+
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWalletNotVerified, siwe.ErrInvalidSignature)
+	}
+
+	signer, err := siwe.RecoverAddress(challenge.Message, sig)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWalletNotVerified, err)
+	}
+	if signer != common.HexToAddress(challenge.WalletAddress) {
+		return fmt.Errorf("%w: signed by %s instead of %s", ErrWalletNotVerified, signer.Hex(), challenge.WalletAddress)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Consuming the challenge first makes a replayed signature fail
+		result := tx.Unscoped().Delete(&challenge)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: no pending wallet challenge", ErrWalletNotVerified)
+		}
+
+		return tx.Model(&Users{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"wallet_address":     challenge.WalletAddress,
+			"wallet_verified_at": time.Now(),
+		}).Error
+	})
+	// The unique index on verified wallets settles two users linking the same one at once
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrWalletInUse
+	}
+
+	return err
+}
+
+// SetRole changes the role of another user and logs them out so their tokens carry it
+func (u *usersService) SetRole(adminId string, id string, role string) error {
+	r, err := rbac.Parse(role)
+	if err != nil {
+		return err
+	}
+
+	// An admin demoting themselves could leave nobody to manage roles
+	if adminId == id {
+		return ErrOwnRole
+	}
+
+	result := u.db.DB().Model(&Users{}).Where("id = ?", id).Update("role", string(r))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	log.Printf("User %s set the role of %s to %s", adminId, id, r)
+	return u.sessions.RevokeAll(id)
+}
+
+// validateSIWE checks a message was issued for this app, on a supported network and is still valid
+func (u *usersService) validateSIWE(m *siwe.Message) error {
+	cfg := u.cfg.GetSIWE()
+
+	domain := cfg.Domain
+	if domain == "" {
+		domain = defaultSIWEDomain
+	}
+	if m.Domain != domain {
+		return fmt.Errorf("domain %s does not match %s", m.Domain, domain)
+	}
+
+	if cfg.URI != "" && m.URI != cfg.URI {
+		return fmt.Errorf("uri %s does not match %s", m.URI, cfg.URI)
+	}
+
+	if _, ok := u.cfg.GetWs().Network(m.ChainID); !ok {
+		return fmt.Errorf("chain id %d is not supported", m.ChainID)
+	}
+
+	return m.Valid(time.Now())
+}
+
+// loadOrCreateByWallet returns the user owning a wallet, registering a new user for an unknown one
+func (u *usersService) loadOrCreateByWallet(address common.Address) (Users, error) {
+	db := u.db.DB()
+
+	var user Users
+	result := db.Where("LOWER(wallet_address) = LOWER(?) AND wallet_verified_at IS NOT NULL", address.Hex()).Order("created_at asc").Limit(1).Find(&user)
+	if result.Error != nil {
+		return Users{}, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return user, nil
+	}
+
+	// Signing in proved owning the wallet
+	verifiedAt := time.Now()
+	user = Users{WalletAddress: address.Hex(), WalletVerifiedAt: &verifiedAt}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		return u.events.Record(tx, events.UserRegistered{UserID: user.ID})
+	})
+	if err != nil {
+		return Users{}, err
+	}
+	u.events.Notify()
+
+	return user, nil
+}
+
+func (u *usersService) nonceTTL() time.Duration {
+	ttl := u.cfg.GetSIWE().NonceTTL
+	if ttl <= 0 {
+		return defaultSIWENonceTTL
+	}
+
+	return ttl
+}
+
 func (u *usersService) load(userId uuid.UUID) Users {
 	db := u.db.DB()
 
@@ -159,23 +822,41 @@ func (u *usersService) save(user *Users) error {
 	}
 
 	user.Password = hashedPassword
+	// Roles and verified wallets are never taken from a registration
+	user.Role = string(rbac.RoleUser)
+	user.WalletVerifiedAt = nil
+	user.TwoFactorEnabledAt = nil
+
+	// The verification mail is queued with the user, so registering does not depend on the mail backend
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
 
-	result = db.Save(&user)
-	if result.Error != nil {
-		return result.Error
-	}
+		verificationLink, err := u.generateEmailVerificationLink(user.ID)
+		if err != nil {
+			return err
+		}
 
-	verificationLink, err := u.generateEmailVerificationLink(user.ID)
-	if err != nil {
-		db.Delete(&user)
-		return err
-	}
+		err = u.mailer.Queue(tx, mailer.Mail{
+			To:       user.Email,
+			Template: mailer.TemplateEmailVerification,
+			Data: map[string]string{
+				"UserName":         user.FirstName,
+				"VerificationLink": verificationLink,
+			},
+		})
+		if err != nil {
+			return err
+		}
 
-	err = email.SendEmailVerification(user.Email, user.FirstName, verificationLink)
+		return u.events.Record(tx, events.UserRegistered{UserID: user.ID, Email: user.Email})
+	})
 	if err != nil {
-		db.Delete(&user)
 		return err
 	}
+	u.mailer.Notify()
+	u.events.Notify()
 
 	return nil
 }
@@ -194,7 +875,7 @@ func (u *usersService) delete(user *Users) error {
 func (u *usersService) update(id uuid.UUID, user *Users) error {
 	db := u.db.DB()
 
-	result := db.Model(&user).Omit("email", "password").Where("id = ?", id).Updates(user)
+	result := db.Model(&user).Omit("email", "password", "wallet_address", "wallet_verified_at", "role", "two_factor_enabled_at").Where("id = ?", id).Updates(user)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -203,7 +884,7 @@ func (u *usersService) update(id uuid.UUID, user *Users) error {
 }
 
 func (u *usersService) generateEmailVerificationLink(id uuid.UUID) (string, error) {
-	token, err := u.jwks.GenerateToken(id.String())
+	token, err := u.jwks.GenerateToken(id.String(), jwt.PurposeEmailVerify)
 	if err != nil {
 		return "", err
 	}
@@ -211,14 +892,47 @@ func (u *usersService) generateEmailVerificationLink(id uuid.UUID) (string, erro
 	return fmt.Sprintf("http://localhost:8000/api/users/verify-email?token=%s", token), nil
 }
 
+// newRecoveryCodes returns random codes formatted as xxxxx-xxxxx for reading them off paper
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts a recovery code typed in another case or without its dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	if len(code) != 10 {
+		return code
+	}
+
+	return code[:5] + "-" + code[5:]
+}
+
+// hashToken is what is stored of a single-use token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func (u *usersService) validateAddress(address string) error {
 	re := regexp.MustCompile("^0x[0-9a-fA-F]{40}$")
 
 	if !re.MatchString(address) {
-		return errors.New("invalid address")
+		return ErrInvalidAddress
 	}
 
-	client, err := ethclient.Dial(u.cfg.GetWs().ETH_URL)
+	client, err := u.ethClient()
 	if err != nil {
 		return err
 	}
@@ -226,12 +940,40 @@ func (u *usersService) validateAddress(address string) error {
 	commonAddress := common.HexToAddress(address)
 	bytecode, err := client.CodeAt(context.Background(), commonAddress, nil)
 	if err != nil {
+		u.resetEthClient(client)
 		return err
 	}
 
 	if len(bytecode) > 0 {
-		return errors.New("invalid address")
+		return fmt.Errorf("%w: contract accounts cannot sign a wallet challenge", ErrInvalidAddress)
 	}
 
 	return nil
 }
+
+// ethClient returns the client of the default network, dialed on first use and shared
+func (u *usersService) ethClient() (*ethclient.Client, error) {
+	u.clientMu.Lock()
+	defer u.clientMu.Unlock()
+
+	if u.client == nil {
+		client, err := ethclient.Dial(u.cfg.GetWs().DefaultNetwork().RPCURL)
+		if err != nil {
+			return nil, err
+		}
+		u.client = client
+	}
+
+	return u.client, nil
+}
+
+// resetEthClient closes a client that failed so the next call dials a new one
+func (u *usersService) resetEthClient(client *ethclient.Client) {
+	u.clientMu.Lock()
+	defer u.clientMu.Unlock()
+
+	if u.client == client {
+		u.client.Close()
+		u.client = nil
+	}
+}
@@ -2,13 +2,19 @@ package users
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
 	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
 	"github.com/TechXTT/bazaar-backend/services/jwt"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
 	"github.com/TechXTT/bazaar-backend/services/middleware"
+	"github.com/TechXTT/bazaar-backend/services/sessions"
 	"github.com/TechXTT/bazaar-backend/services/web"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gorilla/mux"
 	"github.com/mikestefanello/hooks"
 	"github.com/samber/do"
@@ -20,6 +26,68 @@ type (
 		Password string
 	}
 
+	// SIWERequest is a Sign-In with Ethereum message and its personal_sign signature
+	SIWERequest struct {
+		Message   string `json:"message"`
+		Signature string `json:"signature"`
+	}
+
+	// ForgotPasswordRequest asks for a password reset email
+	ForgotPasswordRequest struct {
+		Email string `json:"email"`
+	}
+
+	// ResetPasswordRequest sets a new password with a reset token
+	ResetPasswordRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	// RefreshRequest exchanges a refresh token for new tokens
+	RefreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// WalletChallengeRequest is the wallet a user wants to link to their account
+	WalletChallengeRequest struct {
+		Address string `json:"address"`
+	}
+
+	// WalletVerifyRequest is the personal_sign signature of a wallet challenge
+	WalletVerifyRequest struct {
+		Signature string `json:"signature"`
+	}
+
+	// LoginResult holds the tokens of a new session, or for users with two-factor authentication
+	// the MFA token to complete the login with a code
+	LoginResult struct {
+		*sessions.Tokens
+		MFARequired bool   `json:"mfa_required,omitempty"`
+		MFAToken    string `json:"mfa_token,omitempty"`
+	}
+
+	// TwoFactorLoginRequest completes a login with a TOTP or recovery code
+	TwoFactorLoginRequest struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	// TwoFactorEnrollment is the secret to add to an authenticator app, the URI is meant for a QR code
+	TwoFactorEnrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	// TwoFactorCodeRequest is a TOTP code, or a recovery code where accepted
+	TwoFactorCodeRequest struct {
+		Code string `json:"code"`
+	}
+
+	// RoleRequest is the role an admin gives a user
+	RoleRequest struct {
+		Role string `json:"role"`
+	}
+
 	// Service is the users service interface
 	Service interface {
 		// CreateUser creates a new user
@@ -34,11 +102,55 @@ type (
 		// GetMe returns the current user using JWKS token
 		GetMe(id string) (*Users, error)
 
-		// LoginUser logs in a user
-		LoginUser(email string, password string) (string, error)
+		// LoginUser logs in a user, starting a new session unless a second factor is due
+		LoginUser(email string, password string, client sessions.Client) (*LoginResult, error)
 
 		// VerifyUser verifies a user
 		VerifyUser(token string) error
+
+		// SIWENonce issues a single-use nonce for a Sign-In with Ethereum message
+		SIWENonce() (string, error)
+
+		// LoginWithEthereum verifies a signed Sign-In with Ethereum message and logs in the
+		// user of its wallet, creating one for a new wallet
+		LoginWithEthereum(message string, signature string, client sessions.Client) (*LoginResult, error)
+
+		// LoginTwoFactor completes a login with the MFA token it returned and a TOTP or recovery code
+		LoginTwoFactor(mfaToken string, code string, client sessions.Client) (*sessions.Tokens, error)
+
+		// EnrollTwoFactor issues a new TOTP secret, enabled once a code of it is confirmed
+		EnrollTwoFactor(id string) (*TwoFactorEnrollment, error)
+
+		// ConfirmTwoFactor enables two-factor authentication with a code of the enrolled secret
+		// and returns the recovery codes, which are only shown this once
+		ConfirmTwoFactor(id string, code string) ([]string, error)
+
+		// DisableTwoFactor turns two-factor authentication off with a TOTP or recovery code
+		DisableTwoFactor(id string, code string) error
+
+		// ForgotPassword emails a password reset link if the email belongs to a user
+		ForgotPassword(email string) error
+
+		// ResetPassword sets a new password with a reset token and logs the user out everywhere
+		ResetPassword(token string, password string) error
+
+		// RefreshSession exchanges a refresh token for new tokens of the same session
+		RefreshSession(refreshToken string, client sessions.Client) (*sessions.Tokens, error)
+
+		// Logout revokes a session of a user
+		Logout(id string, sessionID string) error
+
+		// GetSessions returns the active sessions of a user
+		GetSessions(id string) ([]sessions.Session, error)
+
+		// WalletChallenge issues the message a user has to sign with a wallet to link it to their account
+		WalletChallenge(id string, address string) (string, error)
+
+		// VerifyWallet links the wallet of the user's pending challenge once its signature is verified
+		VerifyWallet(id string, signature string) error
+
+		// SetRole changes the role of another user and logs them out, so their tokens carry the new role
+		SetRole(adminId string, id string, role string) error
 	}
 
 	// Handler provides the users handlers
@@ -61,12 +173,64 @@ type (
 
 		// Verify handles a request to verify a user
 		Verify(w http.ResponseWriter, r *http.Request)
+
+		// ForgotPassword handles a request for a password reset email
+		ForgotPassword(w http.ResponseWriter, r *http.Request)
+
+		// ResetPassword handles a request to reset a password
+		ResetPassword(w http.ResponseWriter, r *http.Request)
+
+		// Refresh handles a request to exchange a refresh token
+		Refresh(w http.ResponseWriter, r *http.Request)
+
+		// Logout handles a request to end the current session
+		Logout(w http.ResponseWriter, r *http.Request)
+
+		// Sessions handles a request to list the current user's sessions
+		Sessions(w http.ResponseWriter, r *http.Request)
+
+		// RevokeSession handles a request to end one of the current user's sessions
+		RevokeSession(w http.ResponseWriter, r *http.Request)
+
+		// SIWENonce handles a request for a Sign-In with Ethereum nonce
+		SIWENonce(w http.ResponseWriter, r *http.Request)
+
+		// SIWEVerify handles a request to login with a signed Sign-In with Ethereum message
+		SIWEVerify(w http.ResponseWriter, r *http.Request)
+
+		// WalletChallenge handles a request for a wallet ownership challenge
+		WalletChallenge(w http.ResponseWriter, r *http.Request)
+
+		// WalletVerify handles a request to link a wallet with a signed challenge
+		WalletVerify(w http.ResponseWriter, r *http.Request)
+
+		// SetRole handles an admin's request to change the role of a user
+		SetRole(w http.ResponseWriter, r *http.Request)
+
+		// LoginTwoFactor handles a request to complete a login with a second factor
+		LoginTwoFactor(w http.ResponseWriter, r *http.Request)
+
+		// EnrollTwoFactor handles a request for a new TOTP secret
+		EnrollTwoFactor(w http.ResponseWriter, r *http.Request)
+
+		// ConfirmTwoFactor handles a request to enable two-factor authentication
+		ConfirmTwoFactor(w http.ResponseWriter, r *http.Request)
+
+		// DisableTwoFactor handles a request to disable two-factor authentication
+		DisableTwoFactor(w http.ResponseWriter, r *http.Request)
 	}
 
 	usersService struct {
-		db   db.DB
-		jwks jwt.Jwks
-		cfg  config.Config
+		db       db.DB
+		jwks     jwt.Jwks
+		sessions sessions.Sessions
+		mailer   mailer.Mailer
+		cfg      config.Config
+		events   events.Bus
+
+		// client is the shared ethclient checking linked wallets, see ethClient
+		client   *ethclient.Client
+		clientMu sync.Mutex
 	}
 
 	usersHandler struct {
@@ -93,9 +257,24 @@ func init() {
 		authenticatedHandler.HandleFunc("/users", h.Update).Methods(http.MethodPut)
 		authenticatedHandler.HandleFunc("/users", h.Delete).Methods(http.MethodDelete)
 		authenticatedHandler.HandleFunc("/users/me", h.Me).Methods(http.MethodGet)
+		authenticatedHandler.HandleFunc("/users/logout", h.Logout).Methods(http.MethodPost)
+		authenticatedHandler.HandleFunc("/users/sessions", h.Sessions).Methods(http.MethodGet)
+		authenticatedHandler.HandleFunc("/users/sessions/{id}", h.RevokeSession).Methods(http.MethodDelete)
+		authenticatedHandler.HandleFunc("/users/wallet/challenge", h.WalletChallenge).Methods(http.MethodPost)
+		authenticatedHandler.HandleFunc("/users/wallet/verify", h.WalletVerify).Methods(http.MethodPost)
+		authenticatedHandler.HandleFunc("/users/2fa/enroll", h.EnrollTwoFactor).Methods(http.MethodPost)
+		authenticatedHandler.HandleFunc("/users/2fa/confirm", h.ConfirmTwoFactor).Methods(http.MethodPost)
+		authenticatedHandler.HandleFunc("/users/2fa/disable", h.DisableTwoFactor).Methods(http.MethodPost)
+		authenticatedHandler.Handle("/users/{id}/role", middleware.RequirePermission(rbac.PermissionManageRoles)(http.HandlerFunc(h.SetRole))).Methods(http.MethodPut)
 
 		e.Msg.HandleFunc("/users", h.Create).Methods(http.MethodPost)
 		e.Msg.HandleFunc("/users/login", h.Login).Methods(http.MethodPost)
+		e.Msg.HandleFunc("/users/login/2fa", h.LoginTwoFactor).Methods(http.MethodPost)
+		e.Msg.HandleFunc("/users/refresh", h.Refresh).Methods(http.MethodPost)
+		e.Msg.HandleFunc("/users/forgot-password", h.ForgotPassword).Methods(http.MethodPost)
+		e.Msg.HandleFunc("/users/reset-password", h.ResetPassword).Methods(http.MethodPost)
 		e.Msg.HandleFunc("/users/verify-email", h.Verify).Methods(http.MethodGet)
+		e.Msg.HandleFunc("/users/siwe/nonce", h.SIWENonce).Methods(http.MethodGet)
+		e.Msg.HandleFunc("/users/siwe/verify", h.SIWEVerify).Methods(http.MethodPost)
 	})
 }
@@ -0,0 +1,152 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// EtherDecimals is the number of decimals of every native currency, one ether is 10^18 wei
+const EtherDecimals = 18
+
+var (
+	ErrInvalidAmount  = errors.New("invalid amount")
+	ErrNegativeAmount = errors.New("amount cannot be negative")
+)
+
+// Amount is an exact, non-negative number of a currency's smallest unit, such as wei.
+// It is stored as NUMERIC and encoded in JSON as a decimal string, since uint256
+// values do not fit in a float or a JSON number. The zero value is zero.
+type Amount struct {
+	v *big.Int
+}
+
+// New returns an amount of base units
+func New(units int64) Amount {
+	return FromBig(big.NewInt(units))
+}
+
+// FromBig returns an amount holding a copy of a big integer
+func FromBig(units *big.Int) Amount {
+	if units == nil {
+		return Amount{}
+	}
+
+	return Amount{v: new(big.Int).Set(units)}
+}
+
+// Parse reads a decimal integer of base units
+func Parse(s string) (Amount, error) {
+	units, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if units.Sign() < 0 {
+		return Amount{}, ErrNegativeAmount
+	}
+
+	return Amount{v: units}, nil
+}
+
+// Big returns the amount as a new big integer
+func (a Amount) Big() *big.Int {
+	if a.v == nil {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Set(a.v)
+}
+
+// Cmp compares the amount with a number of base units
+func (a Amount) Cmp(units *big.Int) int {
+	return a.Big().Cmp(units)
+}
+
+// Mul multiplies the amount by a quantity, a negative quantity would make it negative
+func (a Amount) Mul(quantity int64) (Amount, error) {
+	if quantity < 0 {
+		return Amount{}, ErrNegativeAmount
+	}
+
+	return Amount{v: new(big.Int).Mul(a.Big(), big.NewInt(quantity))}, nil
+}
+
+// String returns the amount as a decimal integer of base units
+func (a Amount) String() string {
+	return a.Big().String()
+}
+
+// MarshalJSON encodes the amount as a decimal string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON reads a decimal string, null leaves the amount unchanged
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("%w: amounts are decimal strings", ErrInvalidAmount)
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
+
+// Value stores the amount as a decimal string the NUMERIC column parses
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads an amount from the numeric, text or integer a driver returns
+func (a *Amount) Scan(src interface{}) error {
+	var amount Amount
+	var err error
+
+	switch v := src.(type) {
+	case nil:
+		amount = Amount{}
+	case int64:
+		amount = New(v)
+	case string:
+		amount, err = Parse(v)
+	case []byte:
+		amount, err = Parse(string(v))
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+	return nil
+}
+
+// GormDBDataType stores amounts as NUMERIC wide enough for a uint256, sqlite keeps
+// them as text since its NUMERIC affinity turns large integers into floats
+func (Amount) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "NUMERIC(78,0)"
+	case "sqlite":
+		return "TEXT"
+	}
+
+	return "NUMERIC"
+}
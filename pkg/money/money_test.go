@@ -0,0 +1,148 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// aboveUint64 is 2^64 + 1, past what any fixed size integer column or float holds exactly
+const aboveUint64 = "18446744073709551617"
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "0", want: "0"},
+		{in: " 250000000000000000 ", want: "250000000000000000"},
+		{in: aboveUint64, want: aboveUint64},
+		{in: "115792089237316195423570985008687907853269984665640564039457584007913129639935", want: "115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+		{in: "-1", err: ErrNegativeAmount},
+		{in: "0.5", err: ErrInvalidAmount},
+		{in: "1e18", err: ErrInvalidAmount},
+		{in: "", err: ErrInvalidAmount},
+	} {
+		amount, err := Parse(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && amount.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, amount, tt.want)
+		}
+	}
+}
+
+func TestMul(t *testing.T) {
+	large, _ := Parse(aboveUint64)
+
+	for _, tt := range []struct {
+		amount   Amount
+		quantity int64
+		want     string
+		err      error
+	}{
+		{amount: New(250), quantity: 4, want: "1000"},
+		{amount: New(250), quantity: 0, want: "0"},
+		{amount: Amount{}, quantity: 3, want: "0"},
+		{amount: large, quantity: 2, want: "36893488147419103234"},
+		{amount: New(250), quantity: -1, err: ErrNegativeAmount},
+	} {
+		product, err := tt.amount.Mul(tt.quantity)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s.Mul(%d) error = %v, want %v", tt.amount, tt.quantity, err, tt.err)
+			continue
+		}
+		if err == nil && product.String() != tt.want {
+			t.Errorf("%s.Mul(%d) = %s, want %s", tt.amount, tt.quantity, product, tt.want)
+		}
+	}
+
+	// The receiver is not changed by the multiplication
+	if large.String() != aboveUint64 {
+		t.Errorf("amount after Mul = %s, want %s", large, aboveUint64)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type body struct {
+		Total Amount `json:"total"`
+	}
+
+	for _, tt := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: `{"total":"0"}`, want: "0"},
+		{in: `{"total":"` + aboveUint64 + `"}`, want: aboveUint64},
+		{in: `{"total":null}`, want: "0"},
+		{in: `{"total":1}`, err: ErrInvalidAmount},
+		{in: `{"total":"-1"}`, err: ErrNegativeAmount},
+	} {
+		var decoded body
+		err := json.Unmarshal([]byte(tt.in), &decoded)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("decoding %s error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		encoded, err := json.Marshal(decoded)
+		if err != nil {
+			t.Fatalf("encoding %s: %v", decoded.Total, err)
+		}
+		if want := `{"total":"` + tt.want + `"}`; string(encoded) != want {
+			t.Errorf("round trip of %s = %s, want %s", tt.in, encoded, want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, tt := range []struct {
+		src  interface{}
+		want string
+		err  error
+	}{
+		{src: nil, want: "0"},
+		{src: int64(42), want: "42"},
+		{src: "42", want: "42"},
+		{src: []byte(aboveUint64), want: aboveUint64},
+		{src: aboveUint64, want: aboveUint64},
+		{src: "1.5", err: ErrInvalidAmount},
+		{src: 1.5, err: ErrInvalidAmount},
+	} {
+		var amount Amount
+		err := amount.Scan(tt.src)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Scan(%v) error = %v, want %v", tt.src, err, tt.err)
+			continue
+		}
+		if err == nil && amount.String() != tt.want {
+			t.Errorf("Scan(%v) = %s, want %s", tt.src, amount, tt.want)
+		}
+	}
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	units, _ := new(big.Int).SetString(aboveUint64, 10)
+
+	value, err := FromBig(units).Value()
+	if err != nil {
+		t.Fatalf("valuing: %v", err)
+	}
+
+	var scanned Amount
+	err = scanned.Scan(value)
+	if err != nil {
+		t.Fatalf("scanning %v: %v", value, err)
+	}
+	if scanned.Cmp(units) != 0 {
+		t.Errorf("round trip = %s, want %s", scanned, units)
+	}
+}
@@ -0,0 +1,73 @@
+// Package rbac defines the roles users can have and the permissions each grants
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Role is what a user is allowed to do on the platform, every account starts as RoleUser
+type Role string
+
+// Permission is a single operator action guarded by RequirePermission
+type Permission string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+const (
+	// PermissionResolveDisputes allows closing any dispute, not only one's own
+	PermissionResolveDisputes Permission = "disputes.resolve"
+	// PermissionTakeDownProducts allows removing any listing
+	PermissionTakeDownProducts Permission = "products.takedown"
+	// PermissionManageRoles allows changing the role of any user
+	PermissionManageRoles Permission = "users.roles"
+	// PermissionViewOrders allows reading the status history of any order
+	PermissionViewOrders Permission = "orders.view"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// grants maps every role to the permissions it has
+var grants = map[Role][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermissionResolveDisputes, PermissionTakeDownProducts},
+	RoleAdmin:     {PermissionResolveDisputes, PermissionTakeDownProducts, PermissionManageRoles, PermissionViewOrders},
+}
+
+// Parse returns the role named s, an empty name is RoleUser
+func Parse(s string) (Role, error) {
+	if s == "" {
+		return RoleUser, nil
+	}
+
+	role := Role(s)
+	if _, ok := grants[role]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownRole, s)
+	}
+
+	return role, nil
+}
+
+// Permissions returns the permissions of a role in a stable order, none for an unknown role
+func (r Role) Permissions() []Permission {
+	permissions := append([]Permission(nil), grants[r]...)
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+
+	return permissions
+}
+
+// Can reports whether a role has a permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range grants[r] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
@@ -25,15 +27,27 @@ type (
 		GetWs() WsConfig
 
 		GetS3Spaces() S3SpacesConfig
+
+		GetPricing() PricingConfig
+
+		GetSIWE() SIWEConfig
+
+		GetMailer() MailerConfig
+
+		GetTwoFactor() TwoFactorConfig
 	}
 
 	Base struct {
-		HTTP     HTTPConfig
-		DB       DBConfig
-		App      AppConfig
-		JWT      JWTConfig
-		Ws       WsConfig
-		S3Spaces S3SpacesConfig
+		HTTP      HTTPConfig
+		DB        DBConfig
+		App       AppConfig
+		JWT       JWTConfig
+		Ws        WsConfig
+		S3Spaces  S3SpacesConfig
+		Pricing   PricingConfig
+		SIWE      SIWEConfig
+		Mailer    MailerConfig
+		TwoFactor TwoFactorConfig
 	}
 
 	HTTPConfig struct {
@@ -61,17 +75,79 @@ type (
 	AppConfig struct {
 		Name    string
 		Timeout time.Duration
+		// FrontendURL is the base URL of the frontend links in mails point to
+		FrontendURL string
+	}
+
+	// SIWEConfig is what Sign-In with Ethereum messages must be bound to
+	SIWEConfig struct {
+		// Domain is the host of the frontend requesting the signature
+		Domain string
+		// URI, when set, must be the message's URI
+		URI string
+		// NonceTTL is how long an issued nonce can be used
+		NonceTTL time.Duration
 	}
 
 	JWTConfig struct {
-		JwksUri    string
+		// PrivateKey signs new tokens, PublicKey is optional and must belong to it
 		PrivateKey string
 		PublicKey  string
+		// VerificationKeys are PEM encoded public keys tokens are also accepted from, for
+		// keys being rotated in before signing with them or out until their tokens expire
+		VerificationKeys string
+		// AccessTokenTTL is how long an access token is accepted, revoking its session ends it earlier
+		AccessTokenTTL time.Duration
+		// RefreshTokenTTL is how long a session can be refreshed without logging in again
+		RefreshTokenTTL time.Duration
 	}
 
 	WsConfig struct {
-		ETH_URL         string
-		ContractAddress string
+		// Networks lists the chains orders can settle on, the first one is the default
+		Networks []NetworkConfig
+		// ContractABIPath points to the escrow ABI, defaults to ./Escrow.json
+		ContractABIPath string
+
+		// ObserverMode is "subscribe", "poll" or "auto", which picks based on each RPC URL
+		ObserverMode string
+		// PollInterval is the delay between eth_getLogs calls in poll mode
+		PollInterval time.Duration
+
+		// BackfillRange is the number of blocks requested per FilterLogs call
+		BackfillRange uint64
+
+		// ReleaseDelay is how long the escrow holds an order's funds before the seller can claim them
+		ReleaseDelay time.Duration
+
+		// ReconnectMinBackoff and ReconnectMaxBackoff bound the delay between reconnects
+		ReconnectMinBackoff time.Duration
+		ReconnectMaxBackoff time.Duration
+	}
+
+	// NetworkConfig describes a chain with an escrow deployment
+	NetworkConfig struct {
+		Name          string `json:"name"`
+		ChainID       uint64 `json:"chain_id"`
+		RPCURL        string `json:"rpc_url"`
+		EscrowAddress string `json:"escrow_address"`
+		// Currency is the symbol of the native currency products are priced in
+		Currency string `json:"currency"`
+
+		// Confirmations is the number of blocks a log needs before its status change is applied
+		Confirmations uint64 `json:"confirmations"`
+		// StartBlock is where the observer begins when no cursor is stored,
+		// zero starts from the current head
+		StartBlock uint64 `json:"start_block"`
+
+		// Tokens are the ERC-20 tokens products can be priced in besides the native currency
+		Tokens []TokenConfig `json:"tokens"`
+	}
+
+	// TokenConfig describes a whitelisted ERC-20 token
+	TokenConfig struct {
+		Symbol   string `json:"symbol"`
+		Address  string `json:"address"`
+		Decimals int    `json:"decimals"`
 	}
 
 	S3SpacesConfig struct {
@@ -79,6 +155,45 @@ type (
 		SpacesSecret string
 		SpacesName   string
 	}
+
+	MailerConfig struct {
+		// Backend is "smtp", "file" or "log", there is no default so mails are not logged by accident
+		Backend string
+		// From is the sender address of every mail
+		From string
+
+		SMTPHost     string
+		SMTPPort     int
+		SMTPUsername string
+		SMTPPassword string
+
+		// Dir is where the file backend writes mails
+		Dir string
+	}
+
+	// TwoFactorConfig is how TOTP two-factor authentication is enrolled and enforced
+	TwoFactorConfig struct {
+		// Issuer is the account name shown in authenticator apps
+		Issuer string
+		// RequiredForSellers keeps users without two-factor authentication from opening
+		// stores, listing products and claiming payouts
+		RequiredForSellers bool
+	}
+
+	PricingConfig struct {
+		// Provider is "file" or "coingecko", fiat prices are unavailable without one
+		Provider string
+		// RatesFile is the JSON file read by the file provider
+		RatesFile string
+		// RefreshInterval is the delay between rate fetches
+		RefreshInterval time.Duration
+		// FiatCurrencies are the currencies rates are fetched for
+		FiatCurrencies []string
+
+		// CoinGeckoURL is the API base URL, CoinGeckoIDs maps currency symbols to coin ids
+		CoinGeckoURL string
+		CoinGeckoIDs map[string]string
+	}
 )
 
 func init() {
@@ -132,19 +247,43 @@ func NewConfig(i *do.Injector) (Config, error) {
 	timeout, _ := time.ParseDuration(os.Getenv("APP_TIMEOUT"))
 
 	cfg.App = AppConfig{
-		Name:    os.Getenv("APP_NAME"),
-		Timeout: timeout,
+		Name:        os.Getenv("APP_NAME"),
+		Timeout:     timeout,
+		FrontendURL: os.Getenv("APP_FRONTEND_URL"),
 	}
 
+	accessTokenTTL, _ := time.ParseDuration(os.Getenv("JWT_ACCESS_TTL"))
+	refreshTokenTTL, _ := time.ParseDuration(os.Getenv("JWT_REFRESH_TTL"))
+
 	cfg.JWT = JWTConfig{
-		JwksUri:    os.Getenv("JWKS_URI"),
-		PrivateKey: os.Getenv("PRIVATE_KEY"),
-		PublicKey:  os.Getenv("PUBLIC_KEY"),
+		PrivateKey:       os.Getenv("PRIVATE_KEY"),
+		PublicKey:        os.Getenv("PUBLIC_KEY"),
+		VerificationKeys: os.Getenv("JWT_VERIFICATION_KEYS"),
+		AccessTokenTTL:   accessTokenTTL,
+		RefreshTokenTTL:  refreshTokenTTL,
 	}
 
+	networks, err := loadNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	backfillRange, _ := strconv.ParseUint(os.Getenv("ETH_BACKFILL_RANGE"), 10, 64)
+	pollInterval, _ := time.ParseDuration(os.Getenv("ETH_POLL_INTERVAL"))
+	releaseDelay, _ := time.ParseDuration(os.Getenv("ESCROW_RELEASE_DELAY"))
+	reconnectMinBackoff, _ := time.ParseDuration(os.Getenv("ETH_RECONNECT_MIN_BACKOFF"))
+	reconnectMaxBackoff, _ := time.ParseDuration(os.Getenv("ETH_RECONNECT_MAX_BACKOFF"))
+
 	cfg.Ws = WsConfig{
-		ETH_URL:         os.Getenv("ETH_URL"),
-		ContractAddress: os.Getenv("CONTRACT_ADDRESS"),
+		Networks:        networks,
+		ContractABIPath: os.Getenv("CONTRACT_ABI_PATH"),
+		ObserverMode:    os.Getenv("ETH_OBSERVER_MODE"),
+		PollInterval:    pollInterval,
+		BackfillRange:   backfillRange,
+		ReleaseDelay:    releaseDelay,
+
+		ReconnectMinBackoff: reconnectMinBackoff,
+		ReconnectMaxBackoff: reconnectMaxBackoff,
 	}
 
 	cfg.S3Spaces = S3SpacesConfig{
@@ -153,10 +292,136 @@ func NewConfig(i *do.Injector) (Config, error) {
 		SpacesName:   os.Getenv("SPACES_NAME"),
 	}
 
+	refreshInterval, _ := time.ParseDuration(os.Getenv("PRICING_REFRESH_INTERVAL"))
+
+	// PRICING_COINGECKO_IDS is a list of SYMBOL:coin-id pairs, such as ETH:ethereum
+	coinGeckoIDs := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("PRICING_COINGECKO_IDS"), ",") {
+		symbol, id, ok := strings.Cut(pair, ":")
+		if ok {
+			coinGeckoIDs[strings.ToUpper(strings.TrimSpace(symbol))] = strings.TrimSpace(id)
+		}
+	}
+
+	var fiatCurrencies []string
+	if currencies := os.Getenv("PRICING_FIAT_CURRENCIES"); currencies != "" {
+		fiatCurrencies = strings.Split(currencies, ",")
+	}
+
+	nonceTTL, _ := time.ParseDuration(os.Getenv("SIWE_NONCE_TTL"))
+
+	cfg.SIWE = SIWEConfig{
+		Domain:   os.Getenv("SIWE_DOMAIN"),
+		URI:      os.Getenv("SIWE_URI"),
+		NonceTTL: nonceTTL,
+	}
+
+	cfg.Pricing = PricingConfig{
+		Provider:        os.Getenv("PRICING_PROVIDER"),
+		RatesFile:       os.Getenv("PRICING_RATES_FILE"),
+		RefreshInterval: refreshInterval,
+		FiatCurrencies:  fiatCurrencies,
+		CoinGeckoURL:    os.Getenv("PRICING_COINGECKO_URL"),
+		CoinGeckoIDs:    coinGeckoIDs,
+	}
+
+	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+
+	cfg.Mailer = MailerConfig{
+		Backend:      os.Getenv("MAIL_BACKEND"),
+		From:         os.Getenv("MAIL_FROM"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		Dir:          os.Getenv("MAIL_DIR"),
+	}
+
+	requiredForSellers, _ := strconv.ParseBool(os.Getenv("TOTP_REQUIRED_FOR_SELLERS"))
+
+	cfg.TwoFactor = TwoFactorConfig{
+		Issuer:             os.Getenv("TOTP_ISSUER"),
+		RequiredForSellers: requiredForSellers,
+	}
+
 	return &cfg, nil
 
 }
 
+// loadNetworks reads the ETH_NETWORKS JSON list, without it a single network is
+// built from ETH_URL and CONTRACT_ADDRESS
+func loadNetworks() ([]NetworkConfig, error) {
+	var networks []NetworkConfig
+
+	if raw := os.Getenv("ETH_NETWORKS"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &networks)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ETH_NETWORKS: %w", err)
+		}
+
+		return networks, nil
+	}
+
+	chainID, _ := strconv.ParseUint(os.Getenv("ETH_CHAIN_ID"), 10, 64)
+	startBlock, _ := strconv.ParseUint(os.Getenv("ETH_START_BLOCK"), 10, 64)
+	confirmations, _ := strconv.ParseUint(os.Getenv("ETH_CONFIRMATIONS"), 10, 64)
+
+	currency := os.Getenv("ETH_CURRENCY")
+	if currency == "" {
+		currency = "ETH"
+	}
+
+	var tokens []TokenConfig
+	if raw := os.Getenv("ETH_TOKENS"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &tokens)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ETH_TOKENS: %w", err)
+		}
+	}
+
+	return append(networks, NetworkConfig{
+		Name:          os.Getenv("ETH_NETWORK_NAME"),
+		ChainID:       chainID,
+		RPCURL:        os.Getenv("ETH_URL"),
+		EscrowAddress: os.Getenv("CONTRACT_ADDRESS"),
+		Currency:      currency,
+		Confirmations: confirmations,
+		StartBlock:    startBlock,
+		Tokens:        tokens,
+	}), nil
+}
+
+// Token returns the whitelisted token with the given symbol, ignoring case
+func (n NetworkConfig) Token(symbol string) (TokenConfig, bool) {
+	for _, token := range n.Tokens {
+		if strings.EqualFold(token.Symbol, symbol) {
+			return token, true
+		}
+	}
+
+	return TokenConfig{}, false
+}
+
+// Network returns the configured network with the given chain id
+func (c WsConfig) Network(chainID uint64) (NetworkConfig, bool) {
+	for _, network := range c.Networks {
+		if network.ChainID == chainID {
+			return network, true
+		}
+	}
+
+	return NetworkConfig{}, false
+}
+
+// DefaultNetwork returns the network used when none is chosen
+func (c WsConfig) DefaultNetwork() NetworkConfig {
+	if len(c.Networks) == 0 {
+		return NetworkConfig{}
+	}
+
+	return c.Networks[0]
+}
+
 func (c *Base) GetHTTP() HTTPConfig {
 	return c.HTTP
 }
@@ -180,3 +445,19 @@ func (c *Base) GetWs() WsConfig {
 func (c *Base) GetS3Spaces() S3SpacesConfig {
 	return c.S3Spaces
 }
+
+func (c *Base) GetPricing() PricingConfig {
+	return c.Pricing
+}
+
+func (c *Base) GetSIWE() SIWEConfig {
+	return c.SIWE
+}
+
+func (c *Base) GetMailer() MailerConfig {
+	return c.Mailer
+}
+
+func (c *Base) GetTwoFactor() TwoFactorConfig {
+	return c.TwoFactor
+}
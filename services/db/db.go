@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
 	"github.com/TechXTT/bazaar-backend/services/config"
@@ -38,11 +39,46 @@ func NewDB(i *do.Injector) (DB, error) {
 	}
 
 	db := dbCfg.DB()
+	Migrate(db)
 
+	// Rows written before networks were configurable belong to the default network
+	chainID := dbCfg.cfg.GetWs().DefaultNetwork().ChainID
+	if chainID != 0 {
+		for _, table := range []string{"products", "orders", "reconciliations", "observer_cursors", "processed_logs"} {
+			db.Exec(fmt.Sprintf("UPDATE %s SET chain_id = $1 WHERE chain_id = 0", table), chainID)
+		}
+	}
+
+	return dbCfg, nil
+}
+
+// Migrate creates the missing tables and columns
+func Migrate(db *gorm.DB) {
 	if !db.Migrator().HasTable(&Users{}) {
 		db.Migrator().CreateTable(&Users{})
 		log.Println("Created users table")
 	}
+	if !db.Migrator().HasColumn(&Users{}, "WalletVerifiedAt") {
+		db.Migrator().AddColumn(&Users{}, "WalletVerifiedAt")
+		log.Println("Added wallet_verified_at column to users table")
+	}
+	if !db.Migrator().HasColumn(&Users{}, "Role") {
+		db.Migrator().AddColumn(&Users{}, "Role")
+		log.Println("Added role column to users table")
+	}
+	if !db.Migrator().HasColumn(&Users{}, "TwoFactorEnabledAt") {
+		db.Migrator().AddColumn(&Users{}, "TwoFactorEnabledAt")
+		log.Println("Added two_factor_enabled_at column to users table")
+	}
+	// A wallet is verified by a single user, the condition cannot be expressed with gorm tags
+	if !db.Migrator().HasIndex(&Users{}, "idx_users_verified_wallet") {
+		result := db.Exec("CREATE UNIQUE INDEX idx_users_verified_wallet ON users (LOWER(wallet_address)) WHERE wallet_address <> '' AND wallet_verified_at IS NOT NULL AND deleted_at IS NULL")
+		if result.Error != nil {
+			log.Println("Error creating verified wallet index on users table", result.Error)
+		} else {
+			log.Println("Created verified wallet index on users table")
+		}
+	}
 
 	if !db.Migrator().HasTable(&Stores{}) {
 		db.Migrator().CreateTable(&Stores{})
@@ -53,10 +89,40 @@ func NewDB(i *do.Injector) (DB, error) {
 		db.Migrator().CreateTable(&Products{})
 		log.Println("Created products table")
 	}
+	migrateToWei(db, &Products{}, "price")
+	if !db.Migrator().HasColumn(&Products{}, "ChainID") {
+		db.Migrator().AddColumn(&Products{}, "ChainID")
+		log.Println("Added chain_id column to products table")
+	}
+	if !db.Migrator().HasColumn(&Products{}, "Token") {
+		db.Migrator().AddColumn(&Products{}, "Token")
+		log.Println("Added token column to products table")
+	}
+	if !db.Migrator().HasColumn(&Products{}, "TakenDownBy") {
+		db.Migrator().AddColumn(&Products{}, "TakenDownBy")
+		log.Println("Added taken_down_by column to products table")
+	}
+	if !db.Migrator().HasColumn(&Products{}, "TakenDownAt") {
+		db.Migrator().AddColumn(&Products{}, "TakenDownAt")
+		log.Println("Added taken_down_at column to products table")
+	}
 	if !db.Migrator().HasTable(&Orders{}) {
 		db.Migrator().CreateTable(&Orders{})
 		log.Println("Created orders table")
 	}
+	if !db.Migrator().HasColumn(&Orders{}, "ReleaseTime") {
+		db.Migrator().AddColumn(&Orders{}, "ReleaseTime")
+		log.Println("Added release_time column to orders table")
+	}
+	migrateToWei(db, &Orders{}, "total")
+	if !db.Migrator().HasColumn(&Orders{}, "ChainID") {
+		db.Migrator().AddColumn(&Orders{}, "ChainID")
+		log.Println("Added chain_id column to orders table")
+	}
+	if !db.Migrator().HasColumn(&Orders{}, "Token") {
+		db.Migrator().AddColumn(&Orders{}, "Token")
+		log.Println("Added token column to orders table")
+	}
 	if !db.Migrator().HasTable(&Disputes{}) {
 		db.Migrator().CreateTable(&Disputes{})
 		log.Println("Created disputes table")
@@ -65,8 +131,78 @@ func NewDB(i *do.Injector) (DB, error) {
 		db.Migrator().CreateTable(&DisputeImages{})
 		log.Println("Created dispute_images table")
 	}
-
-	return dbCfg, nil
+	if !db.Migrator().HasTable(&OrderStatusHistory{}) {
+		db.Migrator().CreateTable(&OrderStatusHistory{})
+		log.Println("Created order_status_history table")
+	}
+	if !db.Migrator().HasTable(&Reconciliations{}) {
+		db.Migrator().CreateTable(&Reconciliations{})
+		log.Println("Created reconciliations table")
+	}
+	if !db.Migrator().HasColumn(&Reconciliations{}, "ChainID") {
+		db.Migrator().AddColumn(&Reconciliations{}, "ChainID")
+		log.Println("Added chain_id column to reconciliations table")
+	}
+	if !db.Migrator().HasTable(&ObserverCursors{}) {
+		db.Migrator().CreateTable(&ObserverCursors{})
+		log.Println("Created observer_cursors table")
+	}
+	if !db.Migrator().HasColumn(&ObserverCursors{}, "ChainID") {
+		db.Migrator().AddColumn(&ObserverCursors{}, "ChainID")
+		db.Migrator().DropIndex(&ObserverCursors{}, "idx_observer_cursors_contract_address")
+		db.Migrator().CreateIndex(&ObserverCursors{}, "idx_observer_cursors_chain_contract")
+		log.Println("Added chain_id column to observer_cursors table")
+	}
+	if !db.Migrator().HasTable(&ProcessedLogs{}) {
+		db.Migrator().CreateTable(&ProcessedLogs{})
+		log.Println("Created processed_logs table")
+	}
+	if !db.Migrator().HasColumn(&ProcessedLogs{}, "ChainID") {
+		db.Migrator().AddColumn(&ProcessedLogs{}, "ChainID")
+		db.Migrator().DropIndex(&ProcessedLogs{}, "idx_processed_logs_tx_log")
+		db.Migrator().CreateIndex(&ProcessedLogs{}, "idx_processed_logs_chain_tx_log")
+		log.Println("Added chain_id column to processed_logs table")
+	}
+	if !db.Migrator().HasColumn(&ProcessedLogs{}, "PreviousReleaseTime") {
+		db.Migrator().AddColumn(&ProcessedLogs{}, "PreviousReleaseTime")
+		log.Println("Added previous_release_time column to processed_logs table")
+	}
+	if !db.Migrator().HasTable(&SiweNonces{}) {
+		db.Migrator().CreateTable(&SiweNonces{})
+		log.Println("Created siwe_nonces table")
+	}
+	if !db.Migrator().HasTable(&WalletChallenges{}) {
+		db.Migrator().CreateTable(&WalletChallenges{})
+		log.Println("Created wallet_challenges table")
+	}
+	if !db.Migrator().HasTable(&Sessions{}) {
+		db.Migrator().CreateTable(&Sessions{})
+		log.Println("Created sessions table")
+	}
+	if !db.Migrator().HasTable(&OutboxMails{}) {
+		db.Migrator().CreateTable(&OutboxMails{})
+		log.Println("Created outbox_mails table")
+	}
+	if !db.Migrator().HasColumn(&OutboxMails{}, "ExpiresAt") {
+		db.Migrator().AddColumn(&OutboxMails{}, "ExpiresAt")
+		log.Println("Added expires_at column to outbox_mails table")
+	}
+	if !db.Migrator().HasTable(&PasswordResets{}) {
+		db.Migrator().CreateTable(&PasswordResets{})
+		log.Println("Created password_resets table")
+	}
+	if !db.Migrator().HasTable(&TwoFactors{}) {
+		db.Migrator().CreateTable(&TwoFactors{})
+		log.Println("Created two_factors table")
+	}
+	if !db.Migrator().HasTable(&RecoveryCodes{}) {
+		db.Migrator().CreateTable(&RecoveryCodes{})
+		log.Println("Created recovery_codes table")
+	}
+	if !db.Migrator().HasTable(&OutboxEvents{}) {
+		db.Migrator().CreateTable(&OutboxEvents{})
+		log.Println("Created outbox_events table")
+	}
 }
 
 func (d *db) DB() *gorm.DB {
@@ -79,10 +215,47 @@ func (d *db) DB() *gorm.DB {
 			dbCfg.POSTGRES_USER,
 			dbCfg.POSTGRES_PASSWORD,
 			dbCfg.POSTGRES_DB,
-		), PreferSimpleProtocol: true}), &gorm.Config{})
+		), PreferSimpleProtocol: true}), &gorm.Config{TranslateError: true})
 	if err != nil {
 		panic(err)
 	}
 
 	return db
 }
+
+// migrateToWei converts a float column holding ether amounts to the exact wei
+// NUMERIC used by money.Amount, rounding each value at its shortest decimal form
+func migrateToWei(db *gorm.DB, model interface{}, column string) {
+	columnTypes, err := db.Migrator().ColumnTypes(model)
+	if err != nil {
+		log.Println("Error reading columns", err)
+		return
+	}
+
+	for _, columnType := range columnTypes {
+		if columnType.Name() != column {
+			continue
+		}
+
+		switch strings.ToLower(columnType.DatabaseTypeName()) {
+		case "float4", "float8", "real", "double precision":
+		default:
+			return
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			log.Println("Error parsing model", err)
+			return
+		}
+		table := stmt.Schema.Table
+
+		result := db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE NUMERIC(78,0) USING round(%s::numeric * 1000000000000000000)", table, column, column))
+		if result.Error != nil {
+			log.Printf("Error converting %s.%s to wei: %v", table, column, result.Error)
+			return
+		}
+
+		log.Printf("Converted %s.%s from ETH to wei", table, column)
+	}
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
 	"github.com/gofrs/uuid/v5"
 	"gorm.io/gorm"
 )
@@ -9,7 +12,11 @@ type OrderStatus string
 
 const (
 	OrderStatusPending   OrderStatus = "pending"
+	OrderStatusPaid      OrderStatus = "paid"
+	OrderStatusShipped   OrderStatus = "shipped"
 	OrderStatusCompleted OrderStatus = "completed"
+	OrderStatusReleased  OrderStatus = "released"
+	OrderStatusRefunded  OrderStatus = "refunded"
 	OrderStatusCancelled OrderStatus = "cancelled"
 )
 
@@ -23,6 +30,12 @@ type Users struct {
 	EmailVerified bool   `gorm:"default:false"`
 	Password      string `gorm:"not null"`
 	WalletAddress string
+	// WalletVerifiedAt is when the user proved owning WalletAddress by signing a challenge
+	WalletVerifiedAt *time.Time
+	// Role grants operator permissions, see pkg/rbac
+	Role string `gorm:"not null;default:user"`
+	// TwoFactorEnabledAt is when the user confirmed TOTP enrollment, logins then need a code
+	TwoFactorEnabledAt *time.Time
 }
 
 type Stores struct {
@@ -35,31 +48,184 @@ type Stores struct {
 
 type Products struct {
 	gorm.Model
-	ID       uuid.UUID `gorm:"primaryKey"`
-	Name     string    `gorm:"not null"`
-	ImageURL string    `gorm:"not null"`
-	Price    float64   `gorm:"not null"`
-	Unit     string    `gorm:"not null"`
+	ID       uuid.UUID    `gorm:"primaryKey"`
+	Name     string       `gorm:"not null"`
+	ImageURL string       `gorm:"not null"`
+	Price    money.Amount `gorm:"not null"`
+	Unit     string       `gorm:"not null"`
 	// TODO: Define options for products
 	Description string    `gorm:"not null"`
 	StoreID     uuid.UUID `gorm:"not null"`
 	Store       Stores    `gorm:"foreignKey:StoreID"`
+	// ChainID is the network the product is sold on, its Unit is that network's currency
+	// or the symbol of a whitelisted token
+	ChainID uint64 `gorm:"not null;default:0"`
+	// Token is the address of the ERC-20 token the product is priced in, empty for the native currency
+	Token string
+	// TakenDownBy is the moderator who removed the product, set with TakenDownAt on takedown
+	TakenDownBy *uuid.UUID `gorm:"type:uuid"`
+	TakenDownAt *time.Time
 }
 
 type Orders struct {
 	gorm.Model
-	ID        uuid.UUID   `gorm:"primaryKey"`
-	ProductID uuid.UUID   `gorm:"not null"`
-	Product   Products    `gorm:"foreignKey:ProductID"`
-	BuyerID   uuid.UUID   `gorm:"not null"`
-	Buyer     Users       `gorm:"foreignKey:BuyerID"`
-	Quantity  int         `gorm:"not null"`
-	Total     float64     `gorm:"not null"`
-	Status    OrderStatus `gorm:"not null, type:ENUM('pending', 'completed', 'cancelled'), default:'pending'"`
+	ID        uuid.UUID    `gorm:"primaryKey"`
+	ProductID uuid.UUID    `gorm:"not null"`
+	Product   Products     `gorm:"foreignKey:ProductID"`
+	BuyerID   uuid.UUID    `gorm:"not null"`
+	Buyer     Users        `gorm:"foreignKey:BuyerID"`
+	Quantity  int          `gorm:"not null"`
+	Total     money.Amount `gorm:"not null"`
+	Status    OrderStatus  `gorm:"not null, type:ENUM('pending', 'paid', 'shipped', 'completed', 'released', 'refunded', 'cancelled'), default:'pending'"`
 	TxHash    string
+	// ReleaseTime is when the seller can claim the escrowed funds
+	ReleaseTime *time.Time
+	// ChainID is the network the order settles on, copied from its product
+	ChainID uint64 `gorm:"not null;default:0"`
+	// Token is the ERC-20 token the order is paid in, copied from its product
+	Token string
 	// TODO: add tracking number and shipping address for orders, and txHash for payment
 }
 
+// OrderStatusHistory records every status change of an order
+type OrderStatusHistory struct {
+	gorm.Model
+	ID         uuid.UUID   `gorm:"primaryKey"`
+	OrderID    uuid.UUID   `gorm:"not null;index"`
+	FromStatus OrderStatus `gorm:"not null"`
+	ToStatus   OrderStatus `gorm:"not null"`
+	Actor      string      `gorm:"not null"`
+	Source     string      `gorm:"not null"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
+// SiweNonces holds the Sign-In with Ethereum nonces issued and not used yet
+type SiweNonces struct {
+	gorm.Model
+	Nonce     string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// WalletChallenges holds the message a user has to sign with a wallet to link it to their account
+type WalletChallenges struct {
+	gorm.Model
+	UserID        uuid.UUID `gorm:"not null;index"`
+	WalletAddress string    `gorm:"not null"`
+	Message       string    `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+}
+
+// Reconciliations holds escrow deposits that did not match their order
+type Reconciliations struct {
+	gorm.Model
+	ID       uuid.UUID `gorm:"primaryKey"`
+	OrderID  uuid.UUID
+	ChainID  uint64 `gorm:"not null;default:0"`
+	TxHash   string `gorm:"not null"`
+	Field    string `gorm:"not null"`
+	Expected string
+	Actual   string
+	Resolved bool `gorm:"default:false"`
+}
+
+// ObserverCursors holds the last block fully processed for an escrow contract
+type ObserverCursors struct {
+	gorm.Model
+	ChainID         uint64 `gorm:"not null;default:0;uniqueIndex:idx_observer_cursors_chain_contract"`
+	ContractAddress string `gorm:"not null;uniqueIndex:idx_observer_cursors_chain_contract"`
+	BlockNumber     uint64 `gorm:"not null"`
+}
+
+// ProcessedLogs records the escrow logs that have already been handled
+type ProcessedLogs struct {
+	gorm.Model
+	ChainID     uint64 `gorm:"not null;default:0;uniqueIndex:idx_processed_logs_chain_tx_log"`
+	TxHash      string `gorm:"not null;uniqueIndex:idx_processed_logs_chain_tx_log"`
+	LogIndex    uint   `gorm:"not null;uniqueIndex:idx_processed_logs_chain_tx_log"`
+	BlockNumber uint64 `gorm:"not null"`
+
+	// The order update applied by the log, used to revert it after a reorg
+	OrderID        string
+	PreviousStatus OrderStatus
+	Status         OrderStatus
+	PreviousTxHash string
+	// PreviousReleaseTime is the release time the order had before a deposit set it
+	PreviousReleaseTime *time.Time
+}
+
+// OutboxEvents holds domain events recorded with the change they describe until
+// they are dispatched to their listeners
+type OutboxEvents struct {
+	gorm.Model
+	ID           uuid.UUID `gorm:"primaryKey"`
+	Name         string    `gorm:"not null"`
+	Payload      string    `gorm:"not null"`
+	Attempts     int       `gorm:"not null;default:0"`
+	LastError    string
+	DispatchedAt *time.Time `gorm:"index"`
+}
+
+// OutboxMails holds rendered mails until the mail backend accepted them
+type OutboxMails struct {
+	gorm.Model
+	ID            uuid.UUID `gorm:"primaryKey"`
+	Recipient     string    `gorm:"not null"`
+	Subject       string    `gorm:"not null"`
+	Body          string    `gorm:"not null"`
+	Attempts      int       `gorm:"not null;default:0"`
+	LastError     string
+	NextAttemptAt time.Time  `gorm:"not null;index"`
+	SentAt        *time.Time `gorm:"index"`
+	// ExpiresAt is when the mail's links stop working, it is not sent and its body is wiped after
+	ExpiresAt *time.Time
+}
+
+// PasswordResets holds the hashes of password reset tokens, so each can be used once
+type PasswordResets struct {
+	gorm.Model
+	UserID    uuid.UUID `gorm:"not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// TwoFactors holds the TOTP secret of a user, enabled once a code of it was confirmed
+type TwoFactors struct {
+	gorm.Model
+	UserID uuid.UUID `gorm:"not null;uniqueIndex"`
+	Secret string    `gorm:"not null"`
+	// LastStep is the time step of the last accepted code, so a code cannot be replayed
+	LastStep int64 `gorm:"not null;default:0"`
+	// FailedAttempts wrong codes in a row lock the user out of logging in until LockedUntil
+	FailedAttempts int `gorm:"not null;default:0"`
+	LockedUntil    *time.Time
+}
+
+// RecoveryCodes holds the hashes of the single-use codes replacing a lost authenticator
+type RecoveryCodes struct {
+	gorm.Model
+	UserID   uuid.UUID `gorm:"not null;index"`
+	CodeHash string    `gorm:"not null;index"`
+	UsedAt   *time.Time
+}
+
+// Sessions holds the logins of users, refreshed with a rotating refresh token stored hashed
+type Sessions struct {
+	gorm.Model
+	ID                uuid.UUID `gorm:"primaryKey"`
+	UserID            uuid.UUID `gorm:"not null;index"`
+	RefreshTokenHash  string    `gorm:"not null;uniqueIndex"`
+	PreviousTokenHash string    `gorm:"index"`
+	UserAgent         string
+	IPAddress         string
+	LastUsedAt        time.Time `gorm:"not null"`
+	ExpiresAt         time.Time `gorm:"not null"`
+	RevokedAt         *time.Time
+}
+
 // type Messages struct {
 // 	gorm.Model
 // 	ID        uuid.UUID `gorm:"primaryKey"`
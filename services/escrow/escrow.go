@@ -0,0 +1,276 @@
+package escrow
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+)
+
+var (
+	ErrUnknownNetwork = errors.New("unsupported network")
+	ErrUnknownToken   = errors.New("unsupported token")
+)
+
+const (
+	defaultContractABIPath = "./Escrow.json"
+	defaultReleaseDelay    = 14 * 24 * time.Hour
+)
+
+type (
+	// Escrow provides the escrow contract ABI and encodes calls to it
+	Escrow interface {
+		// Networks returns the networks with an escrow deployment, the first one is the default
+		Networks() []config.NetworkConfig
+
+		// Network returns the network settling orders on a chain
+		Network(chainID uint64) (config.NetworkConfig, error)
+
+		// DefaultNetwork returns the network used when none is chosen
+		DefaultNetwork() config.NetworkConfig
+
+		// Address returns the escrow contract address on a chain
+		Address(chainID uint64) (common.Address, error)
+
+		// Token returns the whitelisted ERC-20 token with the given symbol on a chain,
+		// tokens are only accepted when the escrow contract has the token interface
+		Token(chainID uint64, symbol string) (config.TokenConfig, error)
+
+		// ABI returns the parsed escrow contract ABI
+		ABI() abi.ABI
+
+		// TokenABI returns the parsed ERC-20 ABI of whitelisted tokens
+		TokenABI() abi.ABI
+
+		// ClaimOrdersCalldata encodes a claimOrders call for the given orders
+		ClaimOrdersCalldata(orderIDs []uuid.UUID) ([]byte, error)
+
+		// CreateOrderCalldata encodes the createOrder call a buyer sends with the order value
+		CreateOrderCalldata(orderID uuid.UUID, receiver common.Address, releaseTime time.Time) ([]byte, error)
+
+		// CreateTokenOrderCalldata encodes the createTokenOrder call pulling the order amount of a token from the buyer
+		CreateTokenOrderCalldata(orderID uuid.UUID, receiver common.Address, token common.Address, amount *big.Int, releaseTime time.Time) ([]byte, error)
+
+		// ApproveCalldata encodes the ERC-20 approve call letting the escrow pull a token amount
+		ApproveCalldata(spender common.Address, amount *big.Int) ([]byte, error)
+
+		// ReleaseDelay returns how long after an order its funds are held by the escrow
+		ReleaseDelay() time.Duration
+	}
+
+	escrow struct {
+		networks     []config.NetworkConfig
+		contractABI  abi.ABI
+		tokenABI     abi.ABI
+		releaseDelay time.Duration
+
+		// acceptsTokens is set when the contract ABI declares createTokenOrder and TokenOrderCreated
+		acceptsTokens bool
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewEscrow)
+	})
+}
+
+func NewEscrow(i *do.Injector) (Escrow, error) {
+	cfg := do.MustInvoke[config.Config](i)
+
+	contractABIPath := cfg.GetWs().ContractABIPath
+	if contractABIPath == "" {
+		contractABIPath = defaultContractABIPath
+	}
+
+	fileBytes, err := os.ReadFile(contractABIPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading escrow ABI %s: %w", contractABIPath, err)
+	}
+
+	contractABI, err := abi.JSON(strings.NewReader(string(fileBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing escrow ABI %s: %w", contractABIPath, err)
+	}
+
+	tokenABI, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		return nil, fmt.Errorf("parsing ERC-20 ABI: %w", err)
+	}
+
+	networks := cfg.GetWs().Networks
+	err = validateNetworks(networks)
+	if err != nil {
+		return nil, fmt.Errorf("validating networks: %w", err)
+	}
+
+	// Ether only deployments of the escrow lack the token interface, their whitelists are ignored
+	_, createTokenOrder := contractABI.Methods["createTokenOrder"]
+	_, tokenOrderCreated := contractABI.Events["TokenOrderCreated"]
+	acceptsTokens := createTokenOrder && tokenOrderCreated
+	for _, network := range networks {
+		if len(network.Tokens) > 0 && !acceptsTokens {
+			log.Printf("Escrow ABI %s has no token orders, ignoring the tokens of network %d", contractABIPath, network.ChainID)
+		}
+	}
+
+	releaseDelay := cfg.GetWs().ReleaseDelay
+	if releaseDelay <= 0 {
+		releaseDelay = defaultReleaseDelay
+	}
+
+	return &escrow{
+		networks:     networks,
+		contractABI:  contractABI,
+		tokenABI:     tokenABI,
+		releaseDelay: releaseDelay,
+
+		acceptsTokens: acceptsTokens,
+	}, nil
+}
+
+// validateNetworks checks every network has a distinct nonzero chain id, an escrow address
+// and tokens that can be told apart from each other and from the native currency
+func validateNetworks(networks []config.NetworkConfig) error {
+	if len(networks) == 0 {
+		return errors.New("no network configured")
+	}
+
+	var errs []error
+	seen := map[uint64]bool{}
+	for _, network := range networks {
+		// Orders and cursors are keyed on the chain id, zero is what a missing ETH_CHAIN_ID parses to
+		if network.ChainID == 0 {
+			errs = append(errs, fmt.Errorf("network %q has no chain id", network.Name))
+		}
+		if seen[network.ChainID] {
+			errs = append(errs, fmt.Errorf("chain id %d is configured twice", network.ChainID))
+		}
+		seen[network.ChainID] = true
+
+		if !common.IsHexAddress(network.EscrowAddress) {
+			errs = append(errs, fmt.Errorf("network %d has an invalid escrow address %q", network.ChainID, network.EscrowAddress))
+		}
+
+		symbols := map[string]bool{strings.ToUpper(network.Currency): true}
+		for _, token := range network.Tokens {
+			symbol := strings.ToUpper(token.Symbol)
+			if symbol == "" || symbols[symbol] {
+				errs = append(errs, fmt.Errorf("network %d has a missing or duplicate token symbol %q", network.ChainID, token.Symbol))
+			}
+			symbols[symbol] = true
+
+			if !common.IsHexAddress(token.Address) {
+				errs = append(errs, fmt.Errorf("network %d has an invalid %s address %q", network.ChainID, token.Symbol, token.Address))
+			}
+			if token.Decimals < 0 || token.Decimals > 77 {
+				errs = append(errs, fmt.Errorf("network %d has invalid %s decimals %d", network.ChainID, token.Symbol, token.Decimals))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (e *escrow) Networks() []config.NetworkConfig {
+	return e.networks
+}
+
+func (e *escrow) Network(chainID uint64) (config.NetworkConfig, error) {
+	for _, network := range e.networks {
+		if network.ChainID == chainID {
+			return network, nil
+		}
+	}
+
+	return config.NetworkConfig{}, ErrUnknownNetwork
+}
+
+func (e *escrow) DefaultNetwork() config.NetworkConfig {
+	return e.networks[0]
+}
+
+func (e *escrow) Address(chainID uint64) (common.Address, error) {
+	network, err := e.Network(chainID)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	return common.HexToAddress(network.EscrowAddress), nil
+}
+
+func (e *escrow) Token(chainID uint64, symbol string) (config.TokenConfig, error) {
+	if !e.acceptsTokens {
+		return config.TokenConfig{}, ErrUnknownToken
+	}
+
+	network, err := e.Network(chainID)
+	if err != nil {
+		return config.TokenConfig{}, err
+	}
+
+	token, ok := network.Token(symbol)
+	if !ok {
+		return config.TokenConfig{}, ErrUnknownToken
+	}
+
+	return token, nil
+}
+
+func (e *escrow) ABI() abi.ABI {
+	return e.contractABI
+}
+
+func (e *escrow) TokenABI() abi.ABI {
+	return e.tokenABI
+}
+
+func (e *escrow) ClaimOrdersCalldata(orderIDs []uuid.UUID) ([]byte, error) {
+	ids := make([][32]byte, len(orderIDs))
+	for i, id := range orderIDs {
+		ids[i] = OrderID(id)
+	}
+
+	return e.contractABI.Pack("claimOrders", ids)
+}
+
+func (e *escrow) CreateOrderCalldata(orderID uuid.UUID, receiver common.Address, releaseTime time.Time) ([]byte, error) {
+	return e.contractABI.Pack("createOrder", OrderID(orderID), receiver, big.NewInt(releaseTime.Unix()))
+}
+
+func (e *escrow) CreateTokenOrderCalldata(orderID uuid.UUID, receiver common.Address, token common.Address, amount *big.Int, releaseTime time.Time) ([]byte, error) {
+	return e.contractABI.Pack("createTokenOrder", OrderID(orderID), receiver, token, amount, big.NewInt(releaseTime.Unix()))
+}
+
+func (e *escrow) ApproveCalldata(spender common.Address, amount *big.Int) ([]byte, error) {
+	return e.tokenABI.Pack("approve", spender, amount)
+}
+
+func (e *escrow) ReleaseDelay() time.Duration {
+	return e.releaseDelay
+}
+
+// OrderID encodes an order as the bytes32 id used by the escrow, the UUID without hyphens
+func OrderID(id uuid.UUID) [32]byte {
+	var orderId [32]byte
+	copy(orderId[:], strings.ReplaceAll(id.String(), "-", ""))
+	return orderId
+}
+
+// FormatOrderID restores the hyphenated UUID from a bytes32 escrow order id
+func FormatOrderID(data [32]byte) string {
+	orderId := string(data[:])
+	return strings.Join([]string{orderId[:8], orderId[8:12], orderId[12:16], orderId[16:20], orderId[20:]}, "-")
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/gofrs/uuid/v5"
+)
+
+type (
+	// OrderCreated is published when a buyer places an order
+	OrderCreated struct {
+		OrderID   uuid.UUID `json:"order_id"`
+		ProductID uuid.UUID `json:"product_id"`
+		BuyerID   uuid.UUID `json:"buyer_id"`
+		ChainID   uint64    `json:"chain_id"`
+		Total     string    `json:"total"`
+		Token     string    `json:"token,omitempty"`
+	}
+
+	// OrderStatusChanged is published for every order status change, including reverts
+	OrderStatusChanged struct {
+		OrderID uuid.UUID      `json:"order_id"`
+		From    db.OrderStatus `json:"from"`
+		To      db.OrderStatus `json:"to"`
+		Actor   string         `json:"actor"`
+		Source  string         `json:"source"`
+	}
+
+	// OrderPaid is published when the escrow deposit of an order is accepted
+	OrderPaid struct {
+		OrderID uuid.UUID `json:"order_id"`
+		TxHash  string    `json:"tx_hash"`
+	}
+
+	// OrderCompleted is published when the seller claimed the funds of an order
+	OrderCompleted struct {
+		OrderID uuid.UUID `json:"order_id"`
+	}
+
+	// DisputeOpened is published when a buyer or seller disputes an order
+	DisputeOpened struct {
+		DisputeID uuid.UUID `json:"dispute_id"`
+		OrderID   uuid.UUID `json:"order_id"`
+		UserID    uuid.UUID `json:"user_id"`
+	}
+
+	// DisputeResolved is published when a dispute is closed
+	DisputeResolved struct {
+		DisputeID uuid.UUID `json:"dispute_id"`
+		OrderID   uuid.UUID `json:"order_id"`
+		UserID    uuid.UUID `json:"user_id"`
+	}
+
+	// UserRegistered is published when an account is created
+	UserRegistered struct {
+		UserID uuid.UUID `json:"user_id"`
+		Email  string    `json:"email,omitempty"`
+	}
+)
+
+func (OrderCreated) EventName() string       { return "order.created" }
+func (OrderStatusChanged) EventName() string { return "order.status_changed" }
+func (OrderPaid) EventName() string          { return "order.paid" }
+func (OrderCompleted) EventName() string     { return "order.completed" }
+func (DisputeOpened) EventName() string      { return "dispute.opened" }
+func (DisputeResolved) EventName() string    { return "dispute.resolved" }
+func (UserRegistered) EventName() string     { return "user.registered" }
+
+// Topics of the domain events, listeners register on them during app boot
+var (
+	HookOrderCreated       = NewTopic[OrderCreated]()
+	HookOrderStatusChanged = NewTopic[OrderStatusChanged]()
+	HookOrderPaid          = NewTopic[OrderPaid]()
+	HookOrderCompleted     = NewTopic[OrderCompleted]()
+	HookDisputeOpened      = NewTopic[DisputeOpened]()
+	HookDisputeResolved    = NewTopic[DisputeResolved]()
+	HookUserRegistered     = NewTopic[UserRegistered]()
+)
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+)
+
+const (
+	// pollInterval is how often the outbox is checked for events nobody notified about
+	pollInterval = 5 * time.Second
+	batchSize    = 100
+	// maxAttempts is how many times an event is delivered before it is left for review
+	maxAttempts = 10
+)
+
+type (
+	// Bus publishes domain events through a transactional outbox, so an event is only
+	// delivered once the change it describes is committed and survives a crash in between.
+	// Delivery is at least once, listeners must tolerate an event more than once.
+	Bus interface {
+		// Record stores an event in the outbox as part of the transaction making the change
+		Record(tx *gorm.DB, event Event) error
+
+		// Notify wakes the dispatcher once a transaction recording events committed
+		Notify()
+
+		// Publish records an event on its own and wakes the dispatcher
+		Publish(event Event) error
+
+		// Flush delivers the recorded events that were not dispatched yet
+		Flush() error
+
+		// Run delivers events as they are recorded and retries the ones left behind
+		Run()
+	}
+
+	bus struct {
+		db     db.DB
+		notify chan struct{}
+
+		// mu keeps a single flush at a time, so an event is not delivered twice at once
+		mu sync.Mutex
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewBus)
+	})
+}
+
+func NewBus(i *do.Injector) (Bus, error) {
+	return &bus{
+		db:     do.MustInvoke[db.DB](i),
+		notify: make(chan struct{}, 1),
+	}, nil
+}
+
+func (b *bus) Record(tx *gorm.DB, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&db.OutboxEvents{
+		ID:      id,
+		Name:    event.EventName(),
+		Payload: string(payload),
+	}).Error
+}
+
+func (b *bus) Notify() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *bus) Publish(event Event) error {
+	err := b.Record(b.db.DB(), event)
+	if err != nil {
+		return err
+	}
+
+	b.Notify()
+	return nil
+}
+
+func (b *bus) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		var pending []db.OutboxEvents
+		result := b.db.DB().
+			Where("dispatched_at IS NULL AND attempts < ?", maxAttempts).
+			Order("created_at asc").
+			Limit(batchSize).
+			Find(&pending)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		failed := false
+		for _, event := range pending {
+			err := b.deliver(event)
+			if err != nil {
+				log.Printf("Error dispatching %s event %s: %v", event.Name, event.ID, err)
+				failed = true
+			}
+		}
+
+		// A failed event would be fetched again, it waits for the next flush instead
+		if len(pending) < batchSize || failed {
+			return nil
+		}
+	}
+}
+
+// deliver dispatches an outbox event to its listeners and records the outcome
+func (b *bus) deliver(event db.OutboxEvents) error {
+	err := dispatch(event)
+
+	updates := map[string]interface{}{"attempts": gorm.Expr("attempts + 1")}
+	if err != nil {
+		updates["last_error"] = err.Error()
+	} else {
+		updates["dispatched_at"] = time.Now()
+	}
+
+	result := b.db.DB().Model(&db.OutboxEvents{}).Where("id = ?", event.ID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return err
+}
+
+// dispatch runs the listeners of an event, a panicking listener fails the delivery
+func dispatch(event db.OutboxEvents) (err error) {
+	d, ok := lookupDispatcher(event.Name)
+	if !ok {
+		return fmt.Errorf("no topic %s", event.Name)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("listener panicked: %v", r)
+		}
+	}()
+
+	return d([]byte(event.Payload))
+}
+
+func (b *bus) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := b.Flush()
+		if err != nil {
+			log.Println("Error flushing event outbox", err)
+		}
+
+		select {
+		case <-b.notify:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/glebarez/sqlite"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type (
+	testEvent struct {
+		Value int `json:"value"`
+	}
+
+	testDB struct {
+		db *gorm.DB
+	}
+)
+
+func (testEvent) EventName() string { return "test.event" }
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+var hookTestEvent = NewTopic[testEvent]()
+
+func newTestBus(t *testing.T) (*bus, *gorm.DB) {
+	t.Helper()
+
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "events.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	db.Migrate(database)
+
+	i := do.New()
+	do.ProvideValue[db.DB](i, &testDB{db: database})
+
+	b, err := NewBus(i)
+	if err != nil {
+		t.Fatalf("creating bus: %v", err)
+	}
+
+	return b.(*bus), database
+}
+
+func TestRecordedEventsAreDeliveredAfterCommit(t *testing.T) {
+	b, database := newTestBus(t)
+
+	var received []int
+	hookTestEvent.Listen(func(e hooks.Event[testEvent]) {
+		received = append(received, e.Msg.Value)
+	})
+
+	// A rolled back transaction leaves nothing to deliver
+	database.Transaction(func(tx *gorm.DB) error {
+		b.Record(tx, testEvent{Value: 1})
+		return gorm.ErrInvalidTransaction
+	})
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return b.Record(tx, testEvent{Value: 2})
+	})
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+
+	err = b.Flush()
+	if err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+	err = b.Flush()
+	if err != nil {
+		t.Fatalf("flushing again: %v", err)
+	}
+
+	if len(received) != 1 || received[0] != 2 {
+		t.Errorf("received = %v, want [2]", received)
+	}
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	b, database := newTestBus(t)
+
+	calls := 0
+	hookTestEvent.Listen(func(e hooks.Event[testEvent]) {
+		if e.Msg.Value != 3 {
+			return
+		}
+
+		calls++
+		if calls == 1 {
+			panic("listener failed")
+		}
+	})
+
+	err := b.Publish(testEvent{Value: 3})
+	if err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+
+	b.Flush()
+
+	var event db.OutboxEvents
+	database.First(&event)
+	if event.DispatchedAt != nil || event.Attempts != 1 || event.LastError == "" {
+		t.Fatalf("event after failure = attempts %d, error %q, dispatched %v", event.Attempts, event.LastError, event.DispatchedAt)
+	}
+
+	b.Flush()
+
+	database.First(&event)
+	if event.DispatchedAt == nil || calls != 2 {
+		t.Errorf("event after retry = dispatched %v after %d calls, want dispatched after 2", event.DispatchedAt, calls)
+	}
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mikestefanello/hooks"
+)
+
+type (
+	// Event is a domain event, its name identifies its topic and outbox rows
+	Event interface {
+		EventName() string
+	}
+
+	// Topic is the hook of a domain event with synchronous and asynchronous listeners
+	Topic[T Event] struct {
+		name  string
+		sync  *hooks.Hook[T]
+		async *hooks.Hook[T]
+	}
+
+	// dispatcher decodes an outbox payload and dispatches it to a topic's listeners
+	dispatcher func(payload []byte) error
+)
+
+var (
+	// dispatchers maps every topic name to the function delivering its events
+	dispatchers   = map[string]dispatcher{}
+	dispatchersMu sync.RWMutex
+)
+
+// NewTopic creates the topic of an event type and registers it for outbox delivery
+func NewTopic[T Event]() *Topic[T] {
+	var event T
+	name := event.EventName()
+
+	t := &Topic[T]{
+		name:  name,
+		sync:  hooks.NewHook[T](name),
+		async: hooks.NewHook[T](name + ".async"),
+	}
+
+	dispatchersMu.Lock()
+	defer dispatchersMu.Unlock()
+
+	dispatchers[name] = t.dispatch
+
+	return t
+}
+
+// Name returns the name events of the topic are stored under
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// Listen registers a listener called in the dispatching goroutine, one at a time
+func (t *Topic[T]) Listen(listener hooks.Listener[T]) {
+	t.sync.Listen(listener)
+}
+
+// ListenAsync registers a listener called in its own goroutine
+func (t *Topic[T]) ListenAsync(listener hooks.Listener[T]) {
+	t.async.Listen(listener)
+}
+
+func (t *Topic[T]) dispatch(payload []byte) error {
+	var event T
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return err
+	}
+
+	t.sync.Dispatch(event)
+	t.async.DispatchAsync(event)
+
+	return nil
+}
+
+func lookupDispatcher(name string) (dispatcher, bool) {
+	dispatchersMu.RLock()
+	defer dispatchersMu.RUnlock()
+
+	d, ok := dispatchers[name]
+	return d, ok
+}
@@ -2,6 +2,7 @@ package jwt
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,20 +13,81 @@ import (
 	"github.com/samber/do"
 )
 
+// Purpose is what a token may be used for, carried in its audience so a token issued
+// for one purpose is rejected everywhere else
+type Purpose string
+
+const (
+	PurposeAccess        Purpose = "access"
+	PurposeEmailVerify   Purpose = "email-verify"
+	PurposePasswordReset Purpose = "password-reset"
+	PurposeEmailChange   Purpose = "email-change"
+	// PurposeMFA tokens prove a password was checked and a second factor is still due
+	PurposeMFA Purpose = "mfa"
+)
+
+const issuer = "bazaar"
+
+// lifetimes is how long a token of each purpose is valid, access tokens get theirs from the session
+var lifetimes = map[Purpose]time.Duration{
+	PurposeEmailVerify:   24 * time.Hour,
+	PurposePasswordReset: 30 * time.Minute,
+	PurposeEmailChange:   time.Hour,
+	PurposeMFA:           5 * time.Minute,
+}
+
+var (
+	// ErrNoSession is returned for an access token that was not issued for a session
+	ErrNoSession = errors.New("token has no session")
+	// ErrUnknownPurpose is returned when generating a token for a purpose without a lifetime
+	ErrUnknownPurpose = errors.New("unknown token purpose")
+)
+
 type (
 	Jwks interface {
-		// GenerateToken generates a new JWT token
-		GenerateToken(id string) (string, error)
+		// GenerateToken generates a new JWT token for a purpose, expiring after that purpose's lifetime
+		GenerateToken(id string, purpose Purpose) (string, error)
+
+		// ValidateToken validates a JWT token issued for a purpose and returns its id
+		ValidateToken(token string, purpose Purpose) (string, error)
 
-		// ValidateToken validates a JWT token
-		ValidateToken(token string) (string, error)
+		// GenerateAccessToken generates a JWT token for a user's session expiring after ttl
+		GenerateAccessToken(access Access, ttl time.Duration) (string, error)
+
+		// ValidateAccessToken validates a session JWT token and returns what it grants
+		ValidateAccessToken(token string) (*Access, error)
+
+		// KeySet returns the public keys tokens are accepted from, for other services to verify them
+		KeySet() KeySet
 	}
 
 	jwks struct {
-		cfg config.Config
+		keys *keySet
+	}
+
+	// Access is who an access token was issued to and what they are allowed to do
+	Access struct {
+		UserID      string
+		SessionID   string
+		Role        string
+		Permissions []string
+	}
+
+	// accessClaims ties an access token to the session it was issued for and carries the
+	// role of its user, so permissions are checked without a lookup
+	accessClaims struct {
+		jwt.RegisteredClaims
+		SessionID   string   `json:"sid"`
+		Role        string   `json:"role,omitempty"`
+		Permissions []string `json:"perms,omitempty"`
 	}
 )
 
+// Lifetime returns how long tokens of a purpose are valid, for records kept alongside them
+func Lifetime(purpose Purpose) time.Duration {
+	return lifetimes[purpose]
+}
+
 func init() {
 	// Provide dependencies during app boot process
 	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
@@ -34,26 +96,51 @@ func init() {
 }
 
 func NewJwk(i *do.Injector) (Jwks, error) {
+	keys, err := loadKeySet(do.MustInvoke[config.Config](i).GetJWT())
+	if err != nil {
+		return nil, fmt.Errorf("loading jwt keys: %w", err)
+	}
+
 	return &jwks{
-		cfg: do.MustInvoke[config.Config](i),
+		keys: keys,
 	}, nil
 }
 
-func (j *jwks) GenerateToken(id string) (string, error) {
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(j.cfg.GetJWT().PrivateKey))
+func (j *jwks) GenerateToken(id string, purpose Purpose) (string, error) {
+	lifetime, ok := lifetimes[purpose]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPurpose, purpose)
+	}
+
+	signedToken, err := j.keys.sign(registeredClaims(id, purpose, lifetime))
 	if err != nil {
-		log.Printf("failed to parse private key: %v", err)
+		log.Printf("failed to sign token: %v", err)
 		return "", err
 	}
 
-	claims := jwt.RegisteredClaims{
-		Issuer:    "bazaar",
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
-		ID:        id,
+	return signedToken, nil
+}
+
+func (j *jwks) ValidateToken(token string, purpose Purpose) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	err := j.parse(token, purpose, claims)
+	if err != nil {
+		log.Printf("failed to parse %s token: %v", purpose, err)
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(privateKey)
+	return claims.ID, nil
+}
+
+func (j *jwks) GenerateAccessToken(access Access, ttl time.Duration) (string, error) {
+	claims := accessClaims{
+		RegisteredClaims: registeredClaims(access.UserID, PurposeAccess, ttl),
+		SessionID:        access.SessionID,
+		Role:             access.Role,
+		Permissions:      access.Permissions,
+	}
+
+	signedToken, err := j.keys.sign(claims)
 	if err != nil {
 		log.Printf("failed to sign token: %v", err)
 		return "", err
@@ -62,44 +149,62 @@ func (j *jwks) GenerateToken(id string) (string, error) {
 	return signedToken, nil
 }
 
-func (j *jwks) ValidateToken(token string) (string, error) {
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(j.cfg.GetJWT().PublicKey))
+func (j *jwks) ValidateAccessToken(token string) (*Access, error) {
+	claims := &accessClaims{}
+	err := j.parse(token, PurposeAccess, claims)
 	if err != nil {
-		log.Printf("failed to parse private key: %v", err)
-		return "", err
+		return nil, err
+	}
+
+	if claims.SessionID == "" {
+		return nil, ErrNoSession
 	}
 
-	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Name}))
+	return &Access{
+		UserID:      claims.ID,
+		SessionID:   claims.SessionID,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+	}, nil
+}
 
-	parsedToken, err := parser.ParseWithClaims(
-		token,
-		&jwt.RegisteredClaims{},
-		func(t *jwt.Token) (interface{}, error) { return publicKey, nil },
+func (j *jwks) KeySet() KeySet {
+	return j.keys.public()
+}
+
+// parse verifies a token was signed by an accepted key for a purpose and has not expired
+func (j *jwks) parse(token string, purpose Purpose, claims jwt.Claims) error {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Name}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(string(purpose)),
 	)
+
+	_, err := parser.ParseWithClaims(token, claims, j.keys.keyFunc)
 	if err != nil {
-		log.Printf("failed to parse token: %v", err)
-		return "", err
-	} else if !parsedToken.Valid {
-		log.Printf("token is invalid")
-		return "", err
-	} else if errors.Is(err, jwt.ErrSignatureInvalid) {
-		log.Printf("token signature is invalid")
-		return "", err
-	} else if errors.Is(err, jwt.ErrTokenExpired) {
-		log.Printf("token is expired")
-		return "", err
+		return err
 	}
 
-	claims, ok := parsedToken.Claims.(*jwt.RegisteredClaims)
-	if !ok {
-		log.Printf("failed to parse claims")
-		return "", err
+	// Tokens without an expiry would never stop working
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return err
 	}
-
-	if claims.ExpiresAt.Time.Before(time.Now()) {
-		log.Printf("token is expired")
-		return "", err
+	if exp == nil {
+		return jwt.ErrTokenInvalidClaims
 	}
 
-	return claims.ID, nil
+	return nil
+}
+
+func registeredClaims(id string, purpose Purpose, lifetime time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+
+	return jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{string(purpose)},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		ID:        id,
+	}
 }
@@ -0,0 +1,163 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/services/config"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/samber/do"
+)
+
+type testKey struct {
+	private string
+	public  string
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("encoding public key: %v", err)
+	}
+
+	return testKey{
+		private: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		public:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+	}
+}
+
+func newTestJwks(t *testing.T, cfg config.JWTConfig) Jwks {
+	t.Helper()
+
+	i := do.New()
+	do.ProvideValue[config.Config](i, &config.Base{JWT: cfg})
+
+	j, err := NewJwk(i)
+	if err != nil {
+		t.Fatalf("creating jwks: %v", err)
+	}
+
+	return j
+}
+
+func TestTokensSurviveKeyRotation(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+
+	// The new key is published before it signs anything
+	before := newTestJwks(t, config.JWTConfig{PrivateKey: oldKey.private, PublicKey: oldKey.public, VerificationKeys: newKey.public})
+	token, err := before.GenerateAccessToken(Access{UserID: "user", SessionID: "session"}, time.Minute)
+	if err != nil {
+		t.Fatalf("generating token: %v", err)
+	}
+
+	after := newTestJwks(t, config.JWTConfig{PrivateKey: newKey.private, VerificationKeys: oldKey.public})
+	rotatedToken, err := after.GenerateAccessToken(Access{UserID: "user", SessionID: "session"}, time.Minute)
+	if err != nil {
+		t.Fatalf("generating token: %v", err)
+	}
+
+	for name, j := range map[string]Jwks{"before": before, "after": after} {
+		for _, token := range []string{token, rotatedToken} {
+			access, err := j.ValidateAccessToken(token)
+			if err != nil || access.UserID != "user" || access.SessionID != "session" {
+				t.Errorf("%s rotation: validating = %+v, %v", name, access, err)
+			}
+		}
+
+		if keys := j.KeySet().Keys; len(keys) != 2 || keys[0].Kid == keys[1].Kid {
+			t.Errorf("%s rotation: key set = %+v, want both keys", name, keys)
+		}
+	}
+
+	// Once retired, tokens of the old key are rejected
+	retired := newTestJwks(t, config.JWTConfig{PrivateKey: newKey.private})
+	_, err = retired.ValidateAccessToken(token)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("validating token of a retired key = %v, want %v", err, ErrUnknownKey)
+	}
+	_, err = retired.ValidateAccessToken(rotatedToken)
+	if err != nil {
+		t.Errorf("validating token of the signing key: %v", err)
+	}
+}
+
+func TestMismatchedPublicKeyIsRejected(t *testing.T) {
+	i := do.New()
+	do.ProvideValue[config.Config](i, &config.Base{JWT: config.JWTConfig{PrivateKey: newTestKey(t).private, PublicKey: newTestKey(t).public}})
+
+	_, err := NewJwk(i)
+	if err == nil {
+		t.Error("creating jwks with a public key of another private key succeeded")
+	}
+}
+
+func TestTokensOnlyWorkForTheirPurpose(t *testing.T) {
+	key := newTestKey(t)
+	j := newTestJwks(t, config.JWTConfig{PrivateKey: key.private})
+
+	verifyToken, err := j.GenerateToken("user", PurposeEmailVerify)
+	if err != nil {
+		t.Fatalf("generating token: %v", err)
+	}
+	accessToken, err := j.GenerateAccessToken(Access{UserID: "user", SessionID: "session"}, time.Minute)
+	if err != nil {
+		t.Fatalf("generating access token: %v", err)
+	}
+
+	id, err := j.ValidateToken(verifyToken, PurposeEmailVerify)
+	if err != nil || id != "user" {
+		t.Errorf("validating email verification token = %s, %v", id, err)
+	}
+
+	_, err = j.ValidateToken(verifyToken, PurposePasswordReset)
+	if !errors.Is(err, jwtlib.ErrTokenInvalidAudience) {
+		t.Errorf("validating as password reset token = %v, want %v", err, jwtlib.ErrTokenInvalidAudience)
+	}
+	_, err = j.ValidateAccessToken(verifyToken)
+	if !errors.Is(err, jwtlib.ErrTokenInvalidAudience) {
+		t.Errorf("validating as access token = %v, want %v", err, jwtlib.ErrTokenInvalidAudience)
+	}
+	_, err = j.ValidateToken(accessToken, PurposeEmailVerify)
+	if !errors.Is(err, jwtlib.ErrTokenInvalidAudience) {
+		t.Errorf("validating access token as email verification token = %v, want %v", err, jwtlib.ErrTokenInvalidAudience)
+	}
+
+	_, err = j.GenerateToken("user", PurposeAccess)
+	if !errors.Is(err, ErrUnknownPurpose) {
+		t.Errorf("generating access token without a session = %v, want %v", err, ErrUnknownPurpose)
+	}
+}
+
+func TestAccessTokensCarryRole(t *testing.T) {
+	key := newTestKey(t)
+	j := newTestJwks(t, config.JWTConfig{PrivateKey: key.private})
+
+	token, err := j.GenerateAccessToken(Access{
+		UserID:      "user",
+		SessionID:   "session",
+		Role:        "moderator",
+		Permissions: []string{"disputes.resolve", "products.takedown"},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("generating access token: %v", err)
+	}
+
+	access, err := j.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatalf("validating access token: %v", err)
+	}
+	if access.Role != "moderator" || len(access.Permissions) != 2 || access.Permissions[0] != "disputes.resolve" {
+		t.Errorf("access = %+v", access)
+	}
+}
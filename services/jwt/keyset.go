@@ -0,0 +1,160 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type (
+	// KeySet is a JSON Web Key Set (RFC 7517) of the keys tokens are verified with
+	KeySet struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+
+	// JSONWebKey is the public part of an RSA signing key
+	JSONWebKey struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+
+	// keySet holds the key tokens are signed with and every key they are accepted from
+	keySet struct {
+		signingKey *rsa.PrivateKey
+		signingKid string
+
+		// keys maps the id of each accepted key to its public key, kids keeps their order
+		keys map[string]*rsa.PublicKey
+		kids []string
+	}
+)
+
+// loadKeySet reads the signing key and the verification keys of keys being rotated in or out
+func loadKeySet(cfg config.JWTConfig) (*keySet, error) {
+	signingKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cfg.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	ks := &keySet{
+		signingKey: signingKey,
+		signingKid: keyID(&signingKey.PublicKey),
+		keys:       map[string]*rsa.PublicKey{},
+	}
+	ks.add(&signingKey.PublicKey)
+
+	// The public key is optional, when set it has to belong to the private key
+	if strings.TrimSpace(cfg.PublicKey) != "" {
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(cfg.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key: %w", err)
+		}
+		if !publicKey.Equal(&signingKey.PublicKey) {
+			return nil, errors.New("public key does not match private key")
+		}
+	}
+
+	rest := []byte(cfg.VerificationKeys)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, fmt.Errorf("parsing verification key: %w", err)
+		}
+		ks.add(publicKey)
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, errors.New("verification keys must be PEM encoded public keys")
+	}
+
+	return ks, nil
+}
+
+func (ks *keySet) add(publicKey *rsa.PublicKey) {
+	kid := keyID(publicKey)
+	if _, ok := ks.keys[kid]; ok {
+		return
+	}
+
+	ks.keys[kid] = publicKey
+	ks.kids = append(ks.kids, kid)
+}
+
+// keyFunc picks the key a token was signed with from its kid header, tokens issued
+// before key ids were added are checked against the signing key
+func (ks *keySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return &ks.signingKey.PublicKey, nil
+	}
+
+	publicKey, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	return publicKey, nil
+}
+
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.signingKid
+
+	return token.SignedString(ks.signingKey)
+}
+
+func (ks *keySet) public() KeySet {
+	set := KeySet{Keys: make([]JSONWebKey, 0, len(ks.kids))}
+	for _, kid := range ks.kids {
+		publicKey := ks.keys[kid]
+
+		set.Keys = append(set.Keys, JSONWebKey{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Name,
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		})
+	}
+
+	return set
+}
+
+// keyID is the JWK thumbprint (RFC 7638) of a public key, so the same key always gets the
+// same id on every instance without configuring one
+func keyID(publicKey *rsa.PublicKey) string {
+	// The members are in lexicographic order as the thumbprint requires
+	thumbprint, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+	})
+
+	sum := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultSMTPPort = 587
+
+type (
+	// Backend hands rendered mails over for delivery
+	Backend interface {
+		// Deliver sends a message, an error leaves it queued for a retry
+		Deliver(msg Message) error
+	}
+
+	// Message is a rendered mail
+	Message struct {
+		From    string
+		To      string
+		Subject string
+		HTML    string
+	}
+
+	smtpBackend struct {
+		addr string
+		host string
+		auth smtp.Auth
+	}
+
+	logBackend struct{}
+
+	fileBackend struct {
+		dir string
+	}
+
+	// MemoryBackend keeps delivered mails in memory, for tests
+	MemoryBackend struct {
+		mu       sync.Mutex
+		messages []Message
+		// Err fails every delivery while set
+		Err error
+	}
+)
+
+// NewSMTPBackend returns a backend sending through an SMTP server, authenticating when a username is set
+func NewSMTPBackend(host string, port int, username string, password string) Backend {
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpBackend{
+		addr: host + ":" + strconv.Itoa(port),
+		host: host,
+		auth: auth,
+	}
+}
+
+func (s *smtpBackend) Deliver(msg Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("parsing sender %q: %w", msg.From, err)
+	}
+
+	return smtp.SendMail(s.addr, s.auth, from.Address, []string{msg.To}, msg.bytes())
+}
+
+// NewLogBackend returns a backend logging mails instead of sending them, for development
+func NewLogBackend() Backend {
+	return &logBackend{}
+}
+
+func (l *logBackend) Deliver(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.HTML)
+	return nil
+}
+
+// NewFileBackend returns a backend writing each mail to an .eml file in a directory, for development
+func NewFileBackend(dir string) Backend {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "bazaar-mail")
+	}
+
+	return &fileBackend{dir: dir}
+}
+
+func (f *fileBackend) Deliver(msg Message) error {
+	err := os.MkdirAll(f.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), filepath.Base(msg.To))
+	return os.WriteFile(filepath.Join(f.dir, name), msg.bytes(), 0o644)
+}
+
+// NewMemoryBackend returns a backend keeping mails in memory
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+func (m *MemoryBackend) Deliver(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the mails delivered so far
+func (m *MemoryBackend) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// bytes formats the message as an RFC 5322 mail with an HTML body
+func (msg Message) bytes() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.HTML)
+
+	return b.Bytes()
+}
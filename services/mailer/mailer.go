@@ -0,0 +1,339 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+)
+
+const (
+	TemplateEmailVerification = "email_verification"
+	TemplatePasswordReset     = "password_reset"
+	TemplatePasswordChanged   = "password_changed"
+	TemplateOrderPaid         = "order_paid"
+	TemplateDisputeOpened     = "dispute_opened"
+)
+
+const (
+	defaultFrom = "Bazaar <no-reply@bazaar.example>"
+	// pollInterval is how often the outbox is checked for mails due for a retry
+	pollInterval = 15 * time.Second
+	batchSize    = 50
+	// maxAttempts is how many times a mail is handed to the backend before it is given up on
+	maxAttempts = 10
+	// The delay before a retry doubles with every failed attempt, up to maxBackoff
+	minBackoff = 30 * time.Second
+	maxBackoff = time.Hour
+)
+
+var (
+	ErrUnknownTemplate  = errors.New("unknown mail template")
+	ErrInvalidRecipient = errors.New("invalid mail recipient")
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+type (
+	// Mailer sends transactional mails through an outbox, so a mail is only sent once the
+	// change it belongs to is committed and is retried while the backend is down
+	Mailer interface {
+		// Queue renders a mail and stores it in the outbox as part of a transaction
+		Queue(tx *gorm.DB, mail Mail) error
+
+		// Notify wakes the sender once a transaction queueing mails committed
+		Notify()
+
+		// Send queues a mail on its own and wakes the sender
+		Send(mail Mail) error
+
+		// Flush hands the mails that are due to the backend
+		Flush() error
+
+		// Run sends mails as they are queued and retries the failed ones
+		Run()
+	}
+
+	// Mail is a mail to render from one of the embedded templates
+	Mail struct {
+		To       string
+		Template string
+		Data     any
+		// ExpiresAt is when the links in the mail stop working, an expired mail is given up
+		// on and its body wiped, zero for mails that do not expire
+		ExpiresAt time.Time
+	}
+
+	mailer struct {
+		db        db.DB
+		backend   Backend
+		from      string
+		templates map[string]*template.Template
+		notify    chan struct{}
+
+		// mu keeps a single flush at a time, so a mail is not sent twice at once
+		mu sync.Mutex
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewMailer)
+	})
+}
+
+func NewMailer(i *do.Injector) (Mailer, error) {
+	cfg := do.MustInvoke[config.Config](i).GetMailer()
+
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(do.MustInvoke[db.DB](i), backend, cfg.From)
+}
+
+// New creates a mailer sending through a backend
+func New(database db.DB, backend Backend, from string) (Mailer, error) {
+	if from == "" {
+		from = defaultFrom
+	}
+
+	templates, err := parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	return &mailer{
+		db:        database,
+		backend:   backend,
+		from:      from,
+		templates: templates,
+		notify:    make(chan struct{}, 1),
+	}, nil
+}
+
+// newBackend picks the configured backend, logging mails with their links and tokens
+// is only meant for development, so it has to be chosen explicitly
+func newBackend(cfg config.MailerConfig) (Backend, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "":
+		return nil, errors.New("MAIL_BACKEND is not set, use smtp or file, or log in development")
+	case "log":
+		log.Println("Mails are only logged, set MAIL_BACKEND to smtp to send them")
+		return NewLogBackend(), nil
+	case "file":
+		return NewFileBackend(cfg.Dir), nil
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("smtp mail backend needs SMTP_HOST")
+		}
+		return NewSMTPBackend(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	}
+
+	return nil, fmt.Errorf("unknown mail backend %q", cfg.Backend)
+}
+
+// parseTemplates reads every embedded template, each defines its own "subject"
+func parseTemplates() (map[string]*template.Template, error) {
+	files, err := templateFS.ReadDir("templates")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]*template.Template{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".html")
+
+		t, err := template.ParseFS(templateFS, "templates/"+file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("parsing mail template %s: %w", name, err)
+		}
+		if t.Lookup("subject") == nil {
+			return nil, fmt.Errorf("mail template %s has no subject", name)
+		}
+
+		templates[name] = t
+	}
+
+	return templates, nil
+}
+
+func (m *mailer) Queue(tx *gorm.DB, mail Mail) error {
+	// A single plain address also keeps header injection out of the outbox
+	address, err := netmail.ParseAddress(mail.To)
+	if err != nil || address.Address != mail.To {
+		return fmt.Errorf("%w: %q", ErrInvalidRecipient, mail.To)
+	}
+
+	subject, body, err := m.render(mail)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	outbox := db.OutboxMails{
+		ID:            id,
+		Recipient:     mail.To,
+		Subject:       subject,
+		Body:          body,
+		NextAttemptAt: time.Now(),
+	}
+	if !mail.ExpiresAt.IsZero() {
+		outbox.ExpiresAt = &mail.ExpiresAt
+	}
+
+	return tx.Create(&outbox).Error
+}
+
+func (m *mailer) render(mail Mail) (string, string, error) {
+	t, ok := m.templates[mail.Template]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownTemplate, mail.Template)
+	}
+
+	var subject, body bytes.Buffer
+	err := t.ExecuteTemplate(&subject, "subject", mail.Data)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = t.Execute(&body, mail.Data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+func (m *mailer) Notify() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailer) Send(mail Mail) error {
+	err := m.Queue(m.db.DB(), mail)
+	if err != nil {
+		return err
+	}
+
+	m.Notify()
+	return nil
+}
+
+func (m *mailer) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Mails that will not be sent anymore must not keep their links around
+	result := m.db.DB().Model(&db.OutboxMails{}).
+		Where("sent_at IS NULL AND body <> '' AND (attempts >= ? OR expires_at <= ?)", maxAttempts, now).
+		Update("body", "")
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var due []db.OutboxMails
+	result = m.db.DB().
+		Where("sent_at IS NULL AND attempts < ? AND next_attempt_at <= ? AND (expires_at IS NULL OR expires_at > ?)", maxAttempts, now, now).
+		Order("created_at asc").
+		Limit(batchSize).
+		Find(&due)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for _, mail := range due {
+		err := m.deliver(mail)
+		if err != nil {
+			log.Printf("Error sending mail %s: %v", mail.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// deliver hands an outbox mail to the backend and records the outcome
+func (m *mailer) deliver(mail db.OutboxMails) error {
+	err := m.backend.Deliver(Message{
+		From:    m.from,
+		To:      mail.Recipient,
+		Subject: mail.Subject,
+		HTML:    mail.Body,
+	})
+
+	updates := map[string]interface{}{"attempts": mail.Attempts + 1}
+	if err != nil {
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(backoff(mail.Attempts + 1))
+		if mail.Attempts+1 >= maxAttempts {
+			// Given up on, so its links are wiped like those of a sent mail
+			updates["body"] = ""
+		}
+	} else {
+		// The body holds live links and tokens, only its headers are kept once sent
+		updates["sent_at"] = time.Now()
+		updates["body"] = ""
+	}
+
+	result := m.db.DB().Model(&db.OutboxMails{}).Where("id = ?", mail.ID).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return err
+}
+
+// backoff is the delay before retrying a mail that failed a number of times
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+func (m *mailer) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := m.Flush()
+		if err != nil {
+			log.Println("Error flushing mail outbox", err)
+		}
+
+		select {
+		case <-m.notify:
+		case <-ticker.C:
+		}
+	}
+}
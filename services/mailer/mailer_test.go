@@ -0,0 +1,217 @@
+package mailer
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDB struct {
+	db *gorm.DB
+}
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+func newTestMailer(t *testing.T) (*mailer, *MemoryBackend, *gorm.DB) {
+	t.Helper()
+
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "mailer.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	db.Migrate(database)
+
+	backend := NewMemoryBackend()
+	m, err := New(&testDB{db: database}, backend, "")
+	if err != nil {
+		t.Fatalf("creating mailer: %v", err)
+	}
+
+	return m.(*mailer), backend, database
+}
+
+var verificationMail = Mail{
+	To:       "ada@example.com",
+	Template: TemplateEmailVerification,
+	Data: map[string]string{
+		"UserName":         "Ada",
+		"VerificationLink": "http://localhost:8000/api/users/verify-email?token=abc",
+	},
+}
+
+func TestQueuedMailIsSentAfterCommit(t *testing.T) {
+	m, backend, database := newTestMailer(t)
+
+	// A rolled back transaction leaves nothing to send
+	database.Transaction(func(tx *gorm.DB) error {
+		m.Queue(tx, verificationMail)
+		return gorm.ErrInvalidTransaction
+	})
+	err := database.Transaction(func(tx *gorm.DB) error {
+		return m.Queue(tx, verificationMail)
+	})
+	if err != nil {
+		t.Fatalf("queueing: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = m.Flush()
+		if err != nil {
+			t.Fatalf("flushing: %v", err)
+		}
+	}
+
+	messages := backend.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d mails, want 1", len(messages))
+	}
+	if messages[0].To != verificationMail.To || messages[0].Subject != "Verify your email for Bazaar!" || messages[0].From != defaultFrom {
+		t.Errorf("message = %+v", messages[0])
+	}
+	if !strings.Contains(messages[0].HTML, "Dear Ada,") || !strings.Contains(messages[0].HTML, "token=abc") {
+		t.Errorf("body = %s", messages[0].HTML)
+	}
+
+	// The token in the body is not kept around once the mail is sent
+	var sent db.OutboxMails
+	database.First(&sent)
+	if sent.SentAt == nil || sent.Body != "" {
+		t.Errorf("sent mail = %+v, want it sent without body", sent)
+	}
+}
+
+func TestFailedMailIsRetriedWithBackoff(t *testing.T) {
+	m, backend, database := newTestMailer(t)
+
+	backend.Err = errors.New("connection refused")
+	err := m.Send(verificationMail)
+	if err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+
+	err = m.Flush()
+	if err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	var queued db.OutboxMails
+	database.First(&queued)
+	if queued.Attempts != 1 || queued.SentAt != nil || queued.LastError != "connection refused" {
+		t.Fatalf("queued mail = %+v", queued)
+	}
+	if !queued.NextAttemptAt.After(time.Now()) {
+		t.Errorf("next attempt at %v is not delayed", queued.NextAttemptAt)
+	}
+
+	// Nothing is sent before the next attempt is due
+	backend.Err = nil
+	m.Flush()
+	if len(backend.Messages()) != 0 {
+		t.Fatal("mail was retried before its backoff")
+	}
+
+	database.Model(&db.OutboxMails{}).Where("id = ?", queued.ID).Update("next_attempt_at", time.Now())
+	m.Flush()
+	if len(backend.Messages()) != 1 {
+		t.Fatalf("sent %d mails after the backoff, want 1", len(backend.Messages()))
+	}
+}
+
+func TestUnsentMailsAreWiped(t *testing.T) {
+	m, backend, database := newTestMailer(t)
+
+	reset := Mail{
+		To:       "ada@example.com",
+		Template: TemplatePasswordReset,
+		Data: map[string]string{
+			"UserName":  "Ada",
+			"ResetLink": "http://localhost:3000/reset-password?token=abc",
+		},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	backend.Err = errors.New("connection refused")
+	for _, mail := range []Mail{verificationMail, reset} {
+		err := m.Send(mail)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+	}
+
+	// The verification mail fails its last attempt, the reset mail expires
+	database.Model(&db.OutboxMails{}).Where("recipient = ? AND expires_at IS NULL", verificationMail.To).Update("attempts", maxAttempts-1)
+	database.Model(&db.OutboxMails{}).Where("expires_at IS NOT NULL").Update("expires_at", time.Now().Add(-time.Minute))
+
+	err := m.Flush()
+	if err != nil {
+		t.Fatalf("flushing: %v", err)
+	}
+
+	var mails []db.OutboxMails
+	database.Find(&mails)
+	for _, mail := range mails {
+		if mail.Body != "" || mail.SentAt != nil {
+			t.Errorf("unsent mail = %+v, want it given up on without body", mail)
+		}
+	}
+	if len(mails) != 2 {
+		t.Fatalf("outbox holds %d mails, want 2", len(mails))
+	}
+
+	// Neither is sent once the backend recovers
+	backend.Err = nil
+	database.Model(&db.OutboxMails{}).Where("1 = 1").Update("next_attempt_at", time.Now())
+	m.Flush()
+	if len(backend.Messages()) != 0 {
+		t.Fatalf("sent %d mails, want none", len(backend.Messages()))
+	}
+}
+
+func TestQueueRejectsInvalidMails(t *testing.T) {
+	m, _, database := newTestMailer(t)
+
+	err := m.Queue(database, Mail{To: "ada@example.com\r\nBcc: eve@example.com", Template: TemplateEmailVerification})
+	if !errors.Is(err, ErrInvalidRecipient) {
+		t.Errorf("queueing to an injected recipient = %v, want %v", err, ErrInvalidRecipient)
+	}
+
+	err = m.Queue(database, Mail{To: "ada@example.com", Template: "welcome"})
+	if !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("queueing an unknown template = %v, want %v", err, ErrUnknownTemplate)
+	}
+}
+
+func TestBackendMustBeChosen(t *testing.T) {
+	_, err := newBackend(config.MailerConfig{})
+	if err == nil {
+		t.Error("an unset backend was accepted")
+	}
+
+	backend, err := newBackend(config.MailerConfig{Backend: "log"})
+	if err != nil || backend == nil {
+		t.Errorf("log backend = %v, %v", backend, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: time.Hour,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
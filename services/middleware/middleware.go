@@ -1,10 +1,13 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
 	"github.com/TechXTT/bazaar-backend/services/jwt"
+	"github.com/TechXTT/bazaar-backend/services/sessions"
 	"github.com/mikestefanello/hooks"
 	"github.com/samber/do"
 )
@@ -14,11 +17,17 @@ type (
 	Middleware interface {
 		// AuthMiddleware is the middleware for authentication
 		AuthMiddleware(next http.Handler) http.Handler
+
+		// RequirePermission returns a middleware only letting through users whose role grants
+		// a permission, it must run after AuthMiddleware
+		RequirePermission(permission rbac.Permission) func(next http.Handler) http.Handler
 	}
 
 	middleware struct {
-		jwt jwt.Jwks
+		sessions sessions.Sessions
 	}
+
+	accessKey struct{}
 )
 
 func init() {
@@ -30,7 +39,7 @@ func init() {
 
 func NewMiddleware(i *do.Injector) (Middleware, error) {
 	return &middleware{
-		jwt: do.MustInvoke[jwt.Jwks](i),
+		sessions: do.MustInvoke[sessions.Sessions](i),
 	}, nil
 }
 
@@ -45,14 +54,52 @@ func (m *middleware) AuthMiddleware(next http.Handler) http.Handler {
 
 		token = token[7:]
 
-		id, err := m.jwt.ValidateToken(token)
+		// Tokens of a revoked session are rejected before they expire
+		access, err := m.sessions.Authenticate(token)
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusUnauthorized)
 			return
 		}
 
-		r.Header.Set("user_id", id)
+		r.Header.Set("user_id", access.UserID)
+		r.Header.Set("session_id", access.SessionID)
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessKey{}, access)))
 	})
 }
+
+func (m *middleware) RequirePermission(permission rbac.Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := r.Context().Value(accessKey{}).(*jwt.Access)
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !Can(r, permission) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Can reports whether the access token of an authenticated request grants a permission,
+// for handlers whose rules depend on it without requiring it
+func Can(r *http.Request, permission rbac.Permission) bool {
+	access, ok := r.Context().Value(accessKey{}).(*jwt.Access)
+	if !ok {
+		return false
+	}
+
+	for _, p := range access.Permissions {
+		if p == string(permission) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/jwt"
+)
+
+func TestRequirePermission(t *testing.T) {
+	m := &middleware{}
+	handler := m.RequirePermission(rbac.PermissionResolveDisputes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for name, test := range map[string]struct {
+		access *jwt.Access
+		want   int
+	}{
+		"unauthenticated": {nil, http.StatusUnauthorized},
+		"user":            {&jwt.Access{UserID: "user", Role: "user"}, http.StatusForbidden},
+		"moderator":       {&jwt.Access{UserID: "user", Role: "moderator", Permissions: []string{"disputes.resolve", "products.takedown"}}, http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/disputes/1/resolve", nil)
+		if test.access != nil {
+			r = r.WithContext(context.WithValue(r.Context(), accessKey{}, test.access))
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, test.want)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	for name, test := range map[string]struct {
+		access *jwt.Access
+		want   bool
+	}{
+		"unauthenticated": {nil, false},
+		"user":            {&jwt.Access{UserID: "user", Role: "user"}, false},
+		"moderator":       {&jwt.Access{UserID: "user", Role: "moderator", Permissions: []string{"disputes.resolve", "products.takedown"}}, false},
+		"admin":           {&jwt.Access{UserID: "user", Role: "admin", Permissions: []string{"orders.view"}}, true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/products/orders/1/history", nil)
+		if test.access != nil {
+			r = r.WithContext(context.WithValue(r.Context(), accessKey{}, test.access))
+		}
+
+		got := Can(r, rbac.PermissionViewOrders)
+		if got != test.want {
+			t.Errorf("%s: Can = %v, want %v", name, got, test.want)
+		}
+	}
+}
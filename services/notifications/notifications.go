@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"fmt"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+)
+
+type (
+	// Notifications mails users about the domain events that concern them
+	Notifications interface {
+		// Listen registers the listeners on the domain event topics, once before the bus runs
+		Listen()
+	}
+
+	notifications struct {
+		db     db.DB
+		mailer mailer.Mailer
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewNotifications)
+	})
+}
+
+func NewNotifications(i *do.Injector) (Notifications, error) {
+	return &notifications{
+		db:     do.MustInvoke[db.DB](i),
+		mailer: do.MustInvoke[mailer.Mailer](i),
+	}, nil
+}
+
+func (n *notifications) Listen() {
+	// A panicking listener fails the delivery, so the outbox retries the event
+	events.HookOrderPaid.Listen(func(e hooks.Event[events.OrderPaid]) {
+		err := n.orderPaid(e.Msg)
+		if err != nil {
+			panic(fmt.Errorf("notifying payment of order %s: %w", e.Msg.OrderID, err))
+		}
+	})
+
+	events.HookDisputeOpened.Listen(func(e hooks.Event[events.DisputeOpened]) {
+		err := n.disputeOpened(e.Msg)
+		if err != nil {
+			panic(fmt.Errorf("notifying dispute %s: %w", e.Msg.DisputeID, err))
+		}
+	})
+}
+
+// orderPaid asks the seller to ship an order whose escrow deposit was accepted
+func (n *notifications) orderPaid(event events.OrderPaid) error {
+	order, err := n.order(event.OrderID)
+	if err != nil {
+		return err
+	}
+
+	return n.send(order.Product.Store.Owner, mailer.TemplateOrderPaid, map[string]interface{}{
+		"OrderID":     order.ID.String(),
+		"ProductName": order.Product.Name,
+		"Quantity":    order.Quantity,
+	})
+}
+
+// disputeOpened tells the other party of an order that the user disputed it
+func (n *notifications) disputeOpened(event events.DisputeOpened) error {
+	order, err := n.order(event.OrderID)
+	if err != nil {
+		return err
+	}
+
+	recipient := order.Product.Store.Owner
+	if recipient.ID == event.UserID {
+		recipient = order.Buyer
+	}
+
+	return n.send(recipient, mailer.TemplateDisputeOpened, map[string]interface{}{
+		"OrderID":     order.ID.String(),
+		"ProductName": order.Product.Name,
+	})
+}
+
+func (n *notifications) order(id uuid.UUID) (db.Orders, error) {
+	var order db.Orders
+	result := n.db.DB().Preload("Buyer").Preload("Product.Store.Owner").Where("id = ?", id).First(&order)
+
+	return order, result.Error
+}
+
+// send mails a user, wallet only users have no email and are skipped
+func (n *notifications) send(user db.Users, template string, data map[string]interface{}) error {
+	if user.Email == "" {
+		return nil
+	}
+
+	data["UserName"] = user.FirstName
+
+	return n.mailer.Send(mailer.Mail{
+		To:       user.Email,
+		Template: template,
+		Data:     data,
+	})
+}
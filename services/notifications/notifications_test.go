@@ -0,0 +1,98 @@
+package notifications
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/mailer"
+	"github.com/glebarez/sqlite"
+	"github.com/gofrs/uuid/v5"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDB struct {
+	db *gorm.DB
+}
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+func TestEventsCommittedInTransactionsAreMailed(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	db.Migrate(database)
+
+	backend := mailer.NewMemoryBackend()
+	m, err := mailer.New(&testDB{db: database}, backend, "")
+	if err != nil {
+		t.Fatalf("creating mailer: %v", err)
+	}
+
+	i := do.New()
+	do.ProvideValue[db.DB](i, &testDB{db: database})
+	do.ProvideValue[mailer.Mailer](i, m)
+	do.Provide(i, events.NewBus)
+	do.Provide(i, NewNotifications)
+
+	bus := do.MustInvoke[events.Bus](i)
+	do.MustInvoke[Notifications](i).Listen()
+
+	buyer := db.Users{ID: uuid.Must(uuid.NewV4()), FirstName: "Ada", Email: "ada@example.com"}
+	seller := db.Users{ID: uuid.Must(uuid.NewV4()), FirstName: "Grace", Email: "grace@example.com"}
+	store := db.Stores{ID: uuid.Must(uuid.NewV4()), Name: "Hopper's", OwnerID: seller.ID}
+	product := db.Products{ID: uuid.Must(uuid.NewV4()), Name: "Compiler", Price: money.New(1), StoreID: store.ID}
+	order := db.Orders{ID: uuid.Must(uuid.NewV4()), ProductID: product.ID, BuyerID: buyer.ID, Quantity: 2, Total: money.New(2)}
+	for _, row := range []interface{}{&buyer, &seller, &store, &product, &order} {
+		err = database.Create(row).Error
+		if err != nil {
+			t.Fatalf("creating %T: %v", row, err)
+		}
+	}
+
+	// Events are recorded with the change they describe, the way services publish them
+	err = database.Transaction(func(tx *gorm.DB) error {
+		err := bus.Record(tx, events.OrderPaid{OrderID: order.ID})
+		if err != nil {
+			return err
+		}
+
+		return bus.Record(tx, events.DisputeOpened{DisputeID: uuid.Must(uuid.NewV4()), OrderID: order.ID, UserID: buyer.ID})
+	})
+	if err != nil {
+		t.Fatalf("recording events: %v", err)
+	}
+
+	err = bus.Flush()
+	if err != nil {
+		t.Fatalf("flushing events: %v", err)
+	}
+	err = m.Flush()
+	if err != nil {
+		t.Fatalf("flushing mails: %v", err)
+	}
+
+	var undelivered int64
+	database.Model(&db.OutboxEvents{}).Where("dispatched_at IS NULL").Count(&undelivered)
+	if undelivered != 0 {
+		t.Errorf("undelivered events = %d, want 0", undelivered)
+	}
+
+	messages := backend.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("sent %d mails, want 2", len(messages))
+	}
+	for _, msg := range messages {
+		if msg.To != seller.Email || !strings.Contains(msg.HTML, "Dear Grace,") || !strings.Contains(msg.HTML, order.ID.String()) {
+			t.Errorf("mail = %s to %s, want one about order %s to the seller", msg.Subject, msg.To, order.ID)
+		}
+	}
+}
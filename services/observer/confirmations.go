@@ -0,0 +1,131 @@
+package observer
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+type (
+	logKey struct {
+		TxHash common.Hash
+		Index  uint
+	}
+
+	// statusChange is the order update a log applied, kept so a reorg can undo it
+	statusChange struct {
+		OrderID        string
+		PreviousStatus db.OrderStatus
+		Status         db.OrderStatus
+		PreviousTxHash string
+		// PreviousReleaseTime is restored with PreviousTxHash, a deposit sets both
+		PreviousReleaseTime *time.Time
+	}
+)
+
+// confirmedBlock returns the highest block with enough confirmations at the given head
+func (o *observer) confirmedBlock(head uint64) (uint64, bool) {
+	confirmations := o.network.Confirmations
+	if confirmations <= 1 {
+		return head, true
+	}
+
+	if head+1 < confirmations {
+		return 0, false
+	}
+
+	return head + 1 - confirmations, true
+}
+
+// applyConfirmed processes the pending logs that are deep enough in the chain and
+// moves the cursor up to the confirmed block, never past the last block scanned
+func (o *observer) applyConfirmed(head uint64, scanned uint64) error {
+	confirmed, ok := o.confirmedBlock(head)
+	if !ok {
+		return nil
+	}
+
+	var ready []types.Log
+	for _, vLog := range o.pending {
+		if vLog.BlockNumber <= confirmed {
+			ready = append(ready, vLog)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].BlockNumber != ready[j].BlockNumber {
+			return ready[i].BlockNumber < ready[j].BlockNumber
+		}
+		return ready[i].Index < ready[j].Index
+	})
+
+	for _, vLog := range ready {
+		err := o.processLog(o.wsClient, vLog)
+		if err != nil {
+			return err
+		}
+
+		delete(o.pending, logKey{vLog.TxHash, vLog.Index})
+	}
+
+	if scanned < confirmed {
+		confirmed = scanned
+	}
+
+	return o.saveCursor(confirmed)
+}
+
+// removeLog drops a log that was reorged out, reverting its order update if it was already applied
+func (o *observer) removeLog(vLog types.Log) error {
+	// A buyer verified log is applied before it is confirmed, so a pending log may be processed too
+	delete(o.pending, logKey{vLog.TxHash, vLog.Index})
+
+	var processed db.ProcessedLogs
+	result := o.db.DB().Where("chain_id = ? AND tx_hash = ? AND log_index = ?", o.network.ChainID, vLog.TxHash.Hex(), vLog.Index).Limit(1).Find(&processed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	// The order and the processed log change together, so a failure leaves the log to revert again
+	err := o.db.DB().Transaction(func(tx *gorm.DB) error {
+		if processed.OrderID != "" {
+			if processed.Status != processed.PreviousStatus {
+				err := o.orderState.RevertTx(tx, uuid.FromStringOrNil(processed.OrderID), processed.Status, processed.PreviousStatus, escrowActor, orderstate.SourceChain)
+				if errors.Is(err, orderstate.ErrStatusChanged) {
+					log.Printf("Order %s changed after log %s was applied, status not reverted", processed.OrderID, processed.TxHash)
+				} else if err != nil {
+					return err
+				}
+			}
+
+			result := tx.Exec("UPDATE orders SET tx_hash = $1, release_time = $2 WHERE id = $3 AND tx_hash = $4", processed.PreviousTxHash, processed.PreviousReleaseTime, processed.OrderID, processed.TxHash)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		// Deleted permanently so the log is applied again if it is included in another block
+		return tx.Unscoped().Delete(&processed).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if processed.OrderID != "" {
+		log.Printf("Reverted order %s to %s after log %s was removed", processed.OrderID, processed.PreviousStatus, processed.TxHash)
+	}
+
+	o.bus.Notify()
+	return nil
+}
@@ -0,0 +1,206 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultBackfillRange keeps FilterLogs requests within common provider limits
+const defaultBackfillRange = 2000
+
+// backfill replays the logs emitted since the stored cursor and returns the head it reached
+func (o *observer) backfill() (uint64, error) {
+	ctx := context.Background()
+
+	header, err := o.wsClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	head := header.Number.Uint64()
+
+	// Every unconfirmed log is above the cursor and fetched again, which also drops
+	// logs that were reorged out while polling
+	o.pending = make(map[logKey]types.Log)
+
+	from, ok := o.loadCursor()
+	if ok {
+		from++
+
+		err = o.revertRemoved(from)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		from = o.network.StartBlock
+		if from == 0 {
+			return head, o.saveCursor(head)
+		}
+	}
+
+	step := o.cfg.GetWs().BackfillRange
+	if step == 0 {
+		step = defaultBackfillRange
+	}
+
+	for from <= head {
+		to := from + step - 1
+		if to > head {
+			to = head
+		}
+
+		query := o.filterQuery()
+		query.FromBlock = new(big.Int).SetUint64(from)
+		query.ToBlock = new(big.Int).SetUint64(to)
+
+		logs, err := o.wsClient.FilterLogs(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, vLog := range logs {
+			o.pending[logKey{vLog.TxHash, vLog.Index}] = vLog
+		}
+
+		err = o.applyConfirmed(head, to)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(logs) > 0 {
+			log.Printf("Fetched %d logs from blocks %d to %d", len(logs), from, to)
+		}
+		from = to + 1
+	}
+
+	return head, nil
+}
+
+// revertRemoved reverts the logs applied above the cursor whose transaction left the chain,
+// polling never receives removed logs and a subscription misses them while disconnected
+func (o *observer) revertRemoved(from uint64) error {
+	var processed []db.ProcessedLogs
+	result := o.db.DB().Where("chain_id = ? AND block_number >= ?", o.network.ChainID, from).Find(&processed)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	for _, p := range processed {
+		txHash := common.HexToHash(p.TxHash)
+
+		receipt, err := o.wsClient.TransactionReceipt(context.Background(), txHash)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return err
+		}
+		if err == nil && hasLog(receipt, o.contractAddress, p.LogIndex) {
+			continue
+		}
+
+		err = o.removeLog(types.Log{TxHash: txHash, Index: p.LogIndex})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasLog reports whether a receipt holds a log of the contract at the given index
+func hasLog(receipt *types.Receipt, contract common.Address, index uint) bool {
+	for _, vLog := range receipt.Logs {
+		if vLog.Index == index && vLog.Address == contract {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processLog handles a log read from client once, keyed on its transaction hash and log index.
+// The log is claimed in the transaction applying it, so the subscription and a buyer verifying
+// the same deposit cannot both apply it
+func (o *observer) processLog(client chainClient, vLog types.Log) error {
+	err := o.db.DB().Transaction(func(tx *gorm.DB) error {
+		processed := db.ProcessedLogs{
+			ChainID:     o.network.ChainID,
+			TxHash:      vLog.TxHash.Hex(),
+			LogIndex:    vLog.Index,
+			BlockNumber: vLog.BlockNumber,
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&processed)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		change, err := o.handleLog(tx, client, vLog)
+		if err != nil {
+			return err
+		}
+		if change == nil {
+			return nil
+		}
+
+		return tx.Model(&processed).Updates(db.ProcessedLogs{
+			OrderID:             change.OrderID,
+			PreviousStatus:      change.PreviousStatus,
+			Status:              change.Status,
+			PreviousTxHash:      change.PreviousTxHash,
+			PreviousReleaseTime: change.PreviousReleaseTime,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	o.bus.Notify()
+	return nil
+}
+
+func (o *observer) loadCursor() (uint64, bool) {
+	var cursor db.ObserverCursors
+
+	result := o.db.DB().Where("chain_id = ? AND contract_address = ?", o.network.ChainID, o.contractAddress.Hex()).Limit(1).Find(&cursor)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, false
+	}
+
+	return cursor.BlockNumber, true
+}
+
+// saveCursor moves the cursor forward, it never goes back to an earlier block
+func (o *observer) saveCursor(blockNumber uint64) error {
+	database := o.db.DB()
+
+	var cursor db.ObserverCursors
+	result := database.Where("chain_id = ? AND contract_address = ?", o.network.ChainID, o.contractAddress.Hex()).Limit(1).Find(&cursor)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		cursor = db.ObserverCursors{
+			ChainID:         o.network.ChainID,
+			ContractAddress: o.contractAddress.Hex(),
+			BlockNumber:     blockNumber,
+		}
+		return database.Create(&cursor).Error
+	}
+
+	if blockNumber <= cursor.BlockNumber {
+		return nil
+	}
+
+	return database.Model(&cursor).Update("block_number", blockNumber).Error
+}
@@ -0,0 +1,390 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/samber/do"
+)
+
+func TestDepositMarksOrderPaid(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	order := h.createOrder(wei(t, "0.25"))
+	receipt := h.deposit(order, wei(t, "0.25"))
+
+	paid := h.waitForStatus(order.ID, db.OrderStatusPaid)
+	if paid.TxHash != receipt.TxHash.Hex() {
+		t.Errorf("tx hash = %s, want %s", paid.TxHash, receipt.TxHash.Hex())
+	}
+	if got := h.history(order.ID); got != "pending->paid" {
+		t.Errorf("history = %s, want pending->paid", got)
+	}
+
+	var published int64
+	h.db.Model(&db.OutboxEvents{}).Where("name = ? AND payload LIKE ?", events.OrderPaid{}.EventName(), "%"+order.ID.String()+"%").Count(&published)
+	if published != 1 {
+		t.Errorf("order.paid events = %d, want 1", published)
+	}
+}
+
+func TestMismatchedDepositIsFlagged(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	order := h.createOrder(wei(t, "1"))
+	h.deposit(order, wei(t, "0.5"))
+
+	var reconciliation db.Reconciliations
+	h.eventually("reconciliation", func() bool {
+		return h.db.Where("order_id = ?", order.ID).Limit(1).Find(&reconciliation).RowsAffected == 1
+	})
+
+	if reconciliation.Field != "amount" || reconciliation.Actual != wei(t, "0.5").String() {
+		t.Errorf("reconciliation = %s %s, want amount %s", reconciliation.Field, reconciliation.Actual, wei(t, "0.5"))
+	}
+	if status := h.order(order.ID).Status; status != db.OrderStatusPending {
+		t.Errorf("status = %s, want pending", status)
+	}
+}
+
+func TestMismatchedDepositDoesNotBlockOrder(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	// Anyone can send an empty deposit for an order id before the buyer does
+	order := h.createOrder(wei(t, "1"))
+	h.deposit(order, money.New(0))
+	h.eventually("reconciliation", func() bool {
+		return h.db.Where("order_id = ?", order.ID).Limit(1).Find(&db.Reconciliations{}).RowsAffected == 1
+	})
+	if pinned := h.order(order.ID).TxHash; pinned != "" {
+		t.Fatalf("tx hash = %s after a mismatched deposit, want empty", pinned)
+	}
+
+	receipt := h.deposit(order, wei(t, "1"))
+	paid := h.waitForStatus(order.ID, db.OrderStatusPaid)
+	if paid.TxHash != receipt.TxHash.Hex() {
+		t.Errorf("tx hash = %s, want %s", paid.TxHash, receipt.TxHash.Hex())
+	}
+}
+
+func TestClaimCompletesOrder(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	order := h.createOrder(wei(t, "0.1"))
+	h.deposit(order, wei(t, "0.1"))
+	h.waitForStatus(order.ID, db.OrderStatusPaid)
+
+	h.advance(h.escrow.ReleaseDelay() + time.Minute)
+	h.claim(order)
+
+	h.waitForStatus(order.ID, db.OrderStatusCompleted)
+	if got := h.history(order.ID); got != "pending->paid,paid->completed" {
+		t.Errorf("history = %s, want pending->paid,paid->completed", got)
+	}
+}
+
+func TestBackfillReplaysMissedLogs(t *testing.T) {
+	h := newHarness(t, harnessOptions{StartBlock: 1})
+
+	order := h.createOrder(wei(t, "0.2"))
+	h.deposit(order, wei(t, "0.2"))
+
+	h.start()
+	h.waitForStatus(order.ID, db.OrderStatusPaid)
+
+	var cursor db.ObserverCursors
+	err := h.db.First(&cursor).Error
+	if err != nil {
+		t.Fatalf("loading cursor: %v", err)
+	}
+
+	header, _ := h.backend.HeaderByNumber(context.Background(), nil)
+	if cursor.BlockNumber != header.Number.Uint64() {
+		t.Errorf("cursor = %d, want %d", cursor.BlockNumber, header.Number.Uint64())
+	}
+}
+
+func TestConfirmationsDelayStatus(t *testing.T) {
+	h := newHarness(t, harnessOptions{Confirmations: 3})
+	h.start()
+
+	order := h.createOrder(wei(t, "0.3"))
+	h.deposit(order, wei(t, "0.3"))
+
+	h.mine(1)
+	time.Sleep(100 * time.Millisecond)
+	if status := h.order(order.ID).Status; status != db.OrderStatusPending {
+		t.Fatalf("status after 2 blocks = %s, want pending", status)
+	}
+
+	h.mine(1)
+	h.waitForStatus(order.ID, db.OrderStatusPaid)
+}
+
+func TestReorgRevertsDeposit(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	parent, _ := h.backend.HeaderByNumber(context.Background(), nil)
+
+	order := h.createOrder(wei(t, "0.4"))
+	h.deposit(order, wei(t, "0.4"))
+	h.waitForStatus(order.ID, db.OrderStatusPaid)
+
+	// Replace the deposit block with a longer chain without it
+	err := h.backend.Fork(context.Background(), parent.Hash())
+	if err != nil {
+		t.Fatalf("forking: %v", err)
+	}
+	h.mine(2)
+
+	reverted := h.waitForStatus(order.ID, db.OrderStatusPending)
+	if reverted.TxHash != "" {
+		t.Errorf("tx hash = %s, want empty", reverted.TxHash)
+	}
+}
+
+func TestReorgRevertsVerifiedDeposit(t *testing.T) {
+	for _, mode := range []string{ModeSubscribe, ModePoll} {
+		t.Run(mode, func(t *testing.T) {
+			h := newHarness(t, harnessOptions{Confirmations: 5, Mode: mode})
+			h.start()
+
+			parent, _ := h.backend.HeaderByNumber(context.Background(), nil)
+
+			// A verified deposit is applied while its log still waits for confirmations, on
+			// an order created before release times were stored so the deposit sets one
+			order := h.createOrder(wei(t, "0.4"))
+			receipt := h.deposit(order, wei(t, "0.4"))
+			h.db.Model(&db.Orders{}).Where("id = ?", order.ID).Update("release_time", nil)
+			err := h.observers.VerifyTransaction(order.ChainID, order.ID.String(), receipt.TxHash)
+			if err != nil {
+				t.Fatalf("verifying: %v", err)
+			}
+
+			paid := h.order(order.ID)
+			if paid.ReleaseTime == nil || paid.ReleaseTime.Unix() != order.ReleaseTime.Unix() {
+				t.Fatalf("release time = %v, want the deposited %v", paid.ReleaseTime, order.ReleaseTime)
+			}
+
+			err = h.backend.Fork(context.Background(), parent.Hash())
+			if err != nil {
+				t.Fatalf("forking: %v", err)
+			}
+			h.mine(2)
+
+			reverted := h.waitForStatus(order.ID, db.OrderStatusPending)
+			if reverted.TxHash != "" || reverted.ReleaseTime != nil {
+				t.Errorf("order = %q released at %v, want no tx hash and no release time", reverted.TxHash, reverted.ReleaseTime)
+			}
+			if got := h.history(order.ID); got != "pending->paid,paid->pending" {
+				t.Errorf("history = %s, want pending->paid,paid->pending", got)
+			}
+		})
+	}
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	h := newHarness(t, harnessOptions{Confirmations: 10})
+
+	order := h.createOrder(wei(t, "0.5"))
+	receipt := h.deposit(order, wei(t, "0.5"))
+
+	err := h.observers.VerifyTransaction(order.ChainID, order.ID.String(), receipt.TxHash)
+	if err != nil {
+		t.Fatalf("verifying: %v", err)
+	}
+	if status := h.order(order.ID).Status; status != db.OrderStatusPaid {
+		t.Errorf("status = %s, want paid", status)
+	}
+
+	other := h.createOrder(wei(t, "0.5"))
+	err = h.observers.VerifyTransaction(other.ChainID, other.ID.String(), receipt.TxHash)
+	if !errors.Is(err, ErrDepositNotFound) {
+		t.Errorf("error = %v, want %v", err, ErrDepositNotFound)
+	}
+
+	mismatched := h.createOrder(wei(t, "0.5"))
+	receipt = h.deposit(mismatched, money.New(1))
+	err = h.observers.VerifyTransaction(mismatched.ChainID, mismatched.ID.String(), receipt.TxHash)
+	if !errors.Is(err, ErrDepositMismatch) {
+		t.Errorf("error = %v, want %v", err, ErrDepositMismatch)
+	}
+}
+
+func TestConcurrentVerificationsApplyDepositOnce(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+
+	order := h.createOrder(wei(t, "0.5"))
+	receipt := h.deposit(order, wei(t, "0.5"))
+
+	// Repeated submissions of the same deposit race to apply its log
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.observers.VerifyTransaction(order.ChainID, order.ID.String(), receipt.TxHash)
+		}()
+	}
+	wg.Wait()
+
+	h.waitForStatus(order.ID, db.OrderStatusPaid)
+	if got := h.history(order.ID); got != "pending->paid" {
+		t.Errorf("history = %s, want pending->paid", got)
+	}
+
+	var processed int64
+	h.db.Model(&db.ProcessedLogs{}).Where("tx_hash = ?", receipt.TxHash.Hex()).Count(&processed)
+	if processed != 1 {
+		t.Errorf("processed logs = %d, want 1", processed)
+	}
+}
+
+func TestNetworksSettleTheirOwnOrders(t *testing.T) {
+	h := newHarness(t, harnessOptions{Networks: 2})
+	h.start()
+
+	first := h.createOrderOn(h.chains[0], wei(t, "0.1"))
+	second := h.createOrderOn(h.chains[1], wei(t, "0.2"))
+	h.deposit(first, wei(t, "0.1"))
+	h.deposit(second, wei(t, "0.2"))
+
+	h.waitForStatus(first.ID, db.OrderStatusPaid)
+	h.waitForStatus(second.ID, db.OrderStatusPaid)
+
+	var cursors int64
+	h.db.Model(&db.ObserverCursors{}).Count(&cursors)
+	if cursors != 2 {
+		t.Errorf("cursors = %d, want one per network", cursors)
+	}
+}
+
+func TestDepositOnOtherNetworkIsFlagged(t *testing.T) {
+	h := newHarness(t, harnessOptions{Networks: 2})
+	h.start()
+
+	order := h.createOrderOn(h.chains[1], wei(t, "0.1"))
+	h.depositOn(h.chains[0], order, wei(t, "0.1"))
+
+	var reconciliation db.Reconciliations
+	h.eventually("reconciliation", func() bool {
+		return h.db.Where("order_id = ?", order.ID).Limit(1).Find(&reconciliation).RowsAffected == 1
+	})
+
+	if reconciliation.Field != "chain_id" || reconciliation.ChainID != h.chains[0].network.ChainID {
+		t.Errorf("reconciliation = %s on %d, want chain_id on %d", reconciliation.Field, reconciliation.ChainID, h.chains[0].network.ChainID)
+	}
+	if order := h.order(order.ID); order.Status != db.OrderStatusPending || order.TxHash != "" {
+		t.Errorf("order = %s %q, want pending without tx hash", order.Status, order.TxHash)
+	}
+}
+
+func TestTokenDepositMarksOrderPaid(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	order := h.createTokenOrder("USDC", tokenUnits(t, "25"))
+	receipt := h.depositToken(order, "USDC", tokenUnits(t, "25"))
+
+	paid := h.waitForStatus(order.ID, db.OrderStatusPaid)
+	if paid.TxHash != receipt.TxHash.Hex() {
+		t.Errorf("tx hash = %s, want %s", paid.TxHash, receipt.TxHash.Hex())
+	}
+
+	h.advance(h.escrow.ReleaseDelay() + time.Minute)
+	h.claim(order)
+	h.waitForStatus(order.ID, db.OrderStatusCompleted)
+
+	seller := crypto.PubkeyToAddress(h.sellerKey.PublicKey)
+	if balance := h.tokenBalance("USDC", seller); balance.Cmp(tokenUnits(t, "25").Big()) != 0 {
+		t.Errorf("seller balance = %s, want %s", balance, tokenUnits(t, "25"))
+	}
+}
+
+func TestDepositInOtherTokenIsFlagged(t *testing.T) {
+	h := newHarness(t, harnessOptions{})
+	h.start()
+
+	order := h.createTokenOrder("USDC", tokenUnits(t, "10"))
+	h.depositToken(order, "EURC", tokenUnits(t, "10"))
+
+	var reconciliation db.Reconciliations
+	h.eventually("reconciliation", func() bool {
+		return h.db.Where("order_id = ?", order.ID).Limit(1).Find(&reconciliation).RowsAffected == 1
+	})
+
+	eurc := common.HexToAddress(h.token(h.chains[0], "EURC").Address)
+	if reconciliation.Field != "token" || reconciliation.Actual != eurc.Hex() {
+		t.Errorf("reconciliation = %s %s, want token %s", reconciliation.Field, reconciliation.Actual, eurc.Hex())
+	}
+	if status := h.order(order.ID).Status; status != db.OrderStatusPending {
+		t.Errorf("status = %s, want pending", status)
+	}
+}
+
+func TestFeeOnTransferDepositIsFlagged(t *testing.T) {
+	h := newHarness(t, harnessOptions{TokenFee: 1000})
+	h.start()
+
+	order := h.createTokenOrder("USDC", tokenUnits(t, "10"))
+	h.depositToken(order, "USDC", tokenUnits(t, "10"))
+
+	var reconciliation db.Reconciliations
+	h.eventually("reconciliation", func() bool {
+		return h.db.Where("order_id = ?", order.ID).Limit(1).Find(&reconciliation).RowsAffected == 1
+	})
+
+	// The escrow event reports the full amount while the transfer delivered less
+	if reconciliation.Field != "amount" || reconciliation.Actual != tokenUnits(t, "9.999").String() {
+		t.Errorf("reconciliation = %s %s, want amount %s", reconciliation.Field, reconciliation.Actual, tokenUnits(t, "9.999"))
+	}
+}
+
+func TestEtherOnlyEscrowRejectsTokens(t *testing.T) {
+	i := do.New()
+	do.ProvideValue[config.Config](i, &config.Base{
+		Ws: config.WsConfig{
+			Networks: []config.NetworkConfig{{
+				ChainID:       simulatedChainID.Uint64(),
+				EscrowAddress: common.Address{}.Hex(),
+				Currency:      "ETH",
+				Tokens:        []config.TokenConfig{{Symbol: "USDC", Address: common.Address{}.Hex(), Decimals: 6}},
+			}},
+			ContractABIPath: filepath.Join("..", "..", "Escrow.json"),
+		},
+	})
+
+	e, err := escrow.NewEscrow(i)
+	if err != nil {
+		t.Fatalf("loading escrow: %v", err)
+	}
+
+	events, err := registerEvents(e.ABI())
+	if err != nil {
+		t.Fatalf("registering events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("events = %d, want OrderCreated and OrderCompleted", len(events))
+	}
+
+	_, err = e.Token(simulatedChainID.Uint64(), "USDC")
+	if !errors.Is(err, escrow.ErrUnknownToken) {
+		t.Errorf("error = %v, want %v", err, escrow.ErrUnknownToken)
+	}
+}
@@ -0,0 +1,151 @@
+package observer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gorm.io/gorm"
+)
+
+type (
+	// eventHandler applies a decoded escrow event within tx, reading anything else it needs
+	// through client, and returns the order update it made
+	eventHandler func(o *observer, tx *gorm.DB, client chainClient, vLog types.Log, values map[string]interface{}) (*statusChange, error)
+
+	// eventSpec declares an escrow event the observer depends on
+	eventSpec struct {
+		// Inputs are the event arguments the handler reads, by name and ABI type
+		Inputs map[string]string
+		Handle eventHandler
+
+		// Optional events are skipped when the ABI lacks them, as ether only escrows do
+		Optional bool
+	}
+
+	registeredEvent struct {
+		Event  abi.Event
+		Handle eventHandler
+	}
+)
+
+// escrowActor is recorded in the order history for changes made by escrow events
+const escrowActor = "escrow"
+
+// escrowEvents lists every escrow event the observer handles, a new event only
+// needs an entry here to be subscribed to, decoded and validated against the ABI
+var escrowEvents = map[string]eventSpec{
+	"OrderCreated": {
+		Inputs: map[string]string{
+			"orderId":     "bytes32",
+			"buyer":       "address",
+			"receiver":    "address",
+			"amount":      "uint256",
+			"releaseTime": "uint256",
+		},
+		Handle: (*observer).handleOrderCreated,
+	},
+	"TokenOrderCreated": {
+		Inputs: map[string]string{
+			"orderId":     "bytes32",
+			"buyer":       "address",
+			"receiver":    "address",
+			"token":       "address",
+			"amount":      "uint256",
+			"releaseTime": "uint256",
+		},
+		Handle:   (*observer).handleTokenOrderCreated,
+		Optional: true,
+	},
+	"OrderCompleted": {
+		Inputs: map[string]string{
+			"orderId": "bytes32",
+		},
+		Handle: (*observer).handleOrderCompleted,
+	},
+}
+
+// registerEvents resolves every escrow event in the ABI and fails if a required one
+// is missing or an event does not have the inputs its handler reads
+func registerEvents(contractABI abi.ABI) (map[common.Hash]registeredEvent, error) {
+	names := make([]string, 0, len(escrowEvents))
+	for name := range escrowEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	events := make(map[common.Hash]registeredEvent)
+	var errs []error
+
+	for _, name := range names {
+		spec := escrowEvents[name]
+
+		event, ok := contractABI.Events[name]
+		if !ok && spec.Optional {
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("event %s not found", name))
+			continue
+		}
+
+		inputs := make(map[string]string)
+		for _, input := range event.Inputs {
+			inputs[input.Name] = input.Type.String()
+		}
+
+		for input, inputType := range spec.Inputs {
+			if inputs[input] != inputType {
+				errs = append(errs, fmt.Errorf("event %s has no %s input %s", name, inputType, input))
+			}
+		}
+
+		events[event.ID] = registeredEvent{
+			Event:  event,
+			Handle: spec.Handle,
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return events, nil
+}
+
+// decodeEvent unpacks both the data and the indexed topics of a log
+func decodeEvent(event abi.Event, vLog types.Log) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+
+	err := event.Inputs.UnpackIntoMap(values, vLog.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+
+	if len(vLog.Topics) != len(indexed)+1 {
+		return nil, fmt.Errorf("expected %d topics, got %d", len(indexed)+1, len(vLog.Topics))
+	}
+
+	err = abi.ParseTopicsIntoMap(values, indexed, vLog.Topics[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func (o *observer) handleOrderCompleted(tx *gorm.DB, client chainClient, vLog types.Log, values map[string]interface{}) (*statusChange, error) {
+	return o.applyStatus(tx, escrow.FormatOrderID(values["orderId"].([32]byte)), db.OrderStatusCompleted)
+}
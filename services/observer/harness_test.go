@@ -0,0 +1,696 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/TechXTT/bazaar-backend/services/wsclient"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/glebarez/sqlite"
+	"github.com/gofrs/uuid/v5"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// simulatedChainID is the chain id the simulated backend signs with, networks of a
+// harness are labelled with consecutive ids from it
+var simulatedChainID = big.NewInt(1337)
+
+// tokenSymbols are the ERC-20 tokens whitelisted on every simulated network
+var tokenSymbols = []string{"USDC", "EURC"}
+
+// tokenDecimals is the precision of the simulated tokens
+const tokenDecimals = 6
+
+type (
+	// harness runs the real observer handlers against simulated chains with an
+	// escrow compatible contract and a sqlite database
+	harness struct {
+		t *testing.T
+
+		// chains holds one simulated network per worker, the first is the default
+		chains []*chain
+		// backend is the default network's chain
+		backend   *backends.SimulatedBackend
+		escrow    escrow.Escrow
+		observers *observers
+		// observer is the default network's worker
+		observer *observer
+		db       *gorm.DB
+
+		buyerKey  *ecdsa.PrivateKey
+		sellerKey *ecdsa.PrivateKey
+	}
+
+	// chain is a simulated network with its own escrow deployment
+	chain struct {
+		network config.NetworkConfig
+		backend *backends.SimulatedBackend
+	}
+
+	// harnessOptions tweaks the observer configuration of a harness
+	harnessOptions struct {
+		Confirmations uint64
+		StartBlock    uint64
+		// Networks is the number of simulated networks, one when unset
+		Networks int
+		// TokenFee is the number of base units the tokens burn on every transfer
+		TokenFee uint64
+		// Mode is the observer mode, subscribing when unset
+		Mode string
+	}
+
+	testDB struct {
+		db *gorm.DB
+	}
+)
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+// newHarness deploys the escrow to fresh simulated chains and builds the observer
+// through the same providers the app boots with
+func newHarness(t *testing.T, opts harnessOptions) *harness {
+	t.Helper()
+
+	buyerKey, _ := crypto.GenerateKey()
+	sellerKey, _ := crypto.GenerateKey()
+	deployerKey, _ := crypto.GenerateKey()
+
+	// The shipped Escrow.json is ether only, testdata/escrow.evm also takes token orders
+	abiPath := filepath.Join("testdata", "escrow_tokens.json")
+	contractABI := readEscrowABI(t, abiPath)
+	tokenABI := readTokenABI(t)
+	bytecode := escrowBytecode(t, contractABI, tokenABI)
+	tokenCode := tokenBytecode(t, tokenABI, opts.TokenFee)
+
+	if opts.Networks == 0 {
+		opts.Networks = 1
+	}
+
+	var chains []*chain
+	var networks []config.NetworkConfig
+	funds := new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+	for n := 0; n < opts.Networks; n++ {
+		alloc := core.GenesisAlloc{
+			crypto.PubkeyToAddress(buyerKey.PublicKey):    {Balance: funds},
+			crypto.PubkeyToAddress(sellerKey.PublicKey):   {Balance: funds},
+			crypto.PubkeyToAddress(deployerKey.PublicKey): {Balance: funds},
+		}
+
+		// Tokens are part of the genesis state with the buyer's balance already minted
+		var tokens []config.TokenConfig
+		for k, symbol := range tokenSymbols {
+			address := common.BigToAddress(big.NewInt(int64(0x7000 + k)))
+			alloc[address] = core.GenesisAccount{
+				Code:    tokenCode,
+				Balance: new(big.Int),
+				Storage: map[common.Hash]common.Hash{
+					crypto.Keccak256Hash(common.LeftPadBytes(crypto.PubkeyToAddress(buyerKey.PublicKey).Bytes(), 32)): common.BigToHash(funds),
+				},
+			}
+			tokens = append(tokens, config.TokenConfig{Symbol: symbol, Address: address.Hex(), Decimals: tokenDecimals})
+		}
+
+		backend := backends.NewSimulatedBackend(alloc, 30_000_000)
+		t.Cleanup(func() { backend.Close() })
+
+		address, _, _, err := bind.DeployContract(transactor(t, deployerKey), contractABI, bytecode, backend)
+		if err != nil {
+			t.Fatalf("deploying escrow: %v", err)
+		}
+		backend.Commit()
+
+		network := config.NetworkConfig{
+			Name:          fmt.Sprintf("simulated-%d", n),
+			ChainID:       simulatedChainID.Uint64() + uint64(n),
+			EscrowAddress: address.Hex(),
+			Currency:      "ETH",
+			Confirmations: opts.Confirmations,
+			StartBlock:    opts.StartBlock,
+			Tokens:        tokens,
+		}
+		networks = append(networks, network)
+		chains = append(chains, &chain{network: network, backend: backend})
+	}
+
+	if opts.Mode == "" {
+		opts.Mode = ModeSubscribe
+	}
+
+	database := openTestDB(t)
+
+	i := do.New()
+	do.ProvideValue[config.Config](i, &config.Base{
+		Ws: config.WsConfig{
+			Networks:            networks,
+			ContractABIPath:     abiPath,
+			ObserverMode:        opts.Mode,
+			PollInterval:        10 * time.Millisecond,
+			ReleaseDelay:        time.Hour,
+			ReconnectMinBackoff: 10 * time.Millisecond,
+			ReconnectMaxBackoff: 10 * time.Millisecond,
+		},
+	})
+	do.ProvideValue[db.DB](i, &testDB{db: database})
+	do.Provide(i, wsclient.NewWsClient)
+	do.Provide(i, escrow.NewEscrow)
+	do.Provide(i, events.NewBus)
+	do.Provide(i, orderstate.NewOrderState)
+	do.Provide(i, NewObserver)
+
+	o := do.MustInvoke[Observer](i).(*observers)
+	for n, worker := range o.workers {
+		backend := chains[n].backend
+		worker.dial = func() (chainClient, func(), error) {
+			return backend, func() {}, nil
+		}
+	}
+
+	return &harness{
+		t:         t,
+		chains:    chains,
+		backend:   chains[0].backend,
+		escrow:    do.MustInvoke[escrow.Escrow](i),
+		observers: o,
+		observer:  o.workers[0],
+		db:        database,
+		buyerKey:  buyerKey,
+		sellerKey: sellerKey,
+	}
+}
+
+func readEscrowABI(t *testing.T, path string) abi.ABI {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("reading escrow ABI: %v", err)
+	}
+	defer file.Close()
+
+	contractABI, err := abi.JSON(file)
+	if err != nil {
+		t.Fatalf("parsing escrow ABI: %v", err)
+	}
+
+	return contractABI
+}
+
+// readTokenABI returns the ERC-20 ABI the escrow service pays tokens with
+func readTokenABI(t *testing.T) abi.ABI {
+	t.Helper()
+
+	i := do.New()
+	do.ProvideValue[config.Config](i, &config.Base{
+		Ws: config.WsConfig{
+			Networks:        []config.NetworkConfig{{ChainID: simulatedChainID.Uint64(), EscrowAddress: common.Address{}.Hex()}},
+			ContractABIPath: filepath.Join("testdata", "escrow_tokens.json"),
+		},
+	})
+
+	e, err := escrow.NewEscrow(i)
+	if err != nil {
+		t.Fatalf("loading escrow: %v", err)
+	}
+
+	return e.TokenABI()
+}
+
+// escrowBytecode assembles testdata/escrow.evm with the selectors and topics of the
+// repo's ABI and prefixes it with code deploying it as is
+func escrowBytecode(t *testing.T, contractABI abi.ABI, tokenABI abi.ABI) []byte {
+	t.Helper()
+
+	ids := abiIDs(contractABI)
+	for name, id := range abiIDs(tokenABI) {
+		ids[name] = id
+	}
+
+	runtime := assemble(t, "escrow.evm", ids)
+
+	// PUSH2 size, DUP1, PUSH1 offset, PUSH1 0, CODECOPY, PUSH1 0, RETURN
+	size := len(runtime)
+	deploy := []byte{0x61, byte(size >> 8), byte(size), 0x80, 0x60, 12, 0x60, 0, 0x39, 0x60, 0, 0xf3}
+
+	return append(deploy, runtime...)
+}
+
+// tokenBytecode assembles the runtime code of testdata/erc20.evm, a token taking a
+// fee of the given base units on every transfer
+func tokenBytecode(t *testing.T, tokenABI abi.ABI, fee uint64) []byte {
+	t.Helper()
+
+	ids := abiIDs(tokenABI)
+	ids["Fee"] = strconv.FormatUint(fee, 10)
+
+	return assemble(t, "erc20.evm", ids)
+}
+
+// abiIDs maps every method of an ABI to its selector and every event to its topic
+func abiIDs(contractABI abi.ABI) map[string]string {
+	ids := map[string]string{}
+	for name, method := range contractABI.Methods {
+		ids[name] = "0x" + hex.EncodeToString(method.ID)
+	}
+	for name, event := range contractABI.Events {
+		ids[name] = event.ID.Hex()
+	}
+
+	return ids
+}
+
+// assemble fills in and compiles a file of testdata
+func assemble(t *testing.T, name string, ids map[string]string) []byte {
+	t.Helper()
+
+	tmpl, err := template.ParseFiles(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+
+	var src bytes.Buffer
+	err = tmpl.Execute(&src, ids)
+	if err != nil {
+		t.Fatalf("filling %s: %v", name, err)
+	}
+
+	compiler := asm.NewCompiler(false)
+	compiler.Feed(asm.Lex(src.Bytes(), false))
+	code, errs := compiler.Compile()
+	if len(errs) > 0 {
+		t.Fatalf("assembling %s: %v", name, errs)
+	}
+
+	runtime, err := hex.DecodeString(code)
+	if err != nil {
+		t.Fatalf("decoding %s bytecode: %v", name, err)
+	}
+
+	return runtime
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "bazaar.db") + "?_pragma=busy_timeout(5000)"
+	database, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+
+	// The observer and the test share the database, a single connection keeps sqlite from locking
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db.Migrate(database)
+
+	return database
+}
+
+func transactor(t *testing.T, key *ecdsa.PrivateKey) *bind.TransactOpts {
+	t.Helper()
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, simulatedChainID)
+	if err != nil {
+		t.Fatalf("creating transactor: %v", err)
+	}
+
+	return opts
+}
+
+// start runs every worker's subscription in the background and waits until they are connected
+func (h *harness) start() {
+	h.t.Helper()
+
+	for _, worker := range h.observers.workers {
+		go worker.RunSubscription()
+	}
+
+	h.eventually("observer connected", func() bool {
+		for _, status := range h.observers.Status() {
+			if status.State != StateConnected {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// chain returns the simulated network with the given chain id
+func (h *harness) chain(chainID uint64) *chain {
+	h.t.Helper()
+
+	for _, c := range h.chains {
+		if c.network.ChainID == chainID {
+			return c
+		}
+	}
+
+	h.t.Fatalf("no simulated network %d", chainID)
+	return nil
+}
+
+// createOrder stores a pending order settling on the default network
+func (h *harness) createOrder(total money.Amount) db.Orders {
+	h.t.Helper()
+
+	return h.createOrderOn(h.chains[0], total)
+}
+
+// createOrderOn stores a pending order of a product sold by the seller on a network
+func (h *harness) createOrderOn(c *chain, total money.Amount) db.Orders {
+	h.t.Helper()
+
+	buyer := db.Users{
+		ID:            uuid.Must(uuid.NewV4()),
+		FirstName:     "Buyer",
+		LastName:      "Test",
+		Email:         uuid.Must(uuid.NewV4()).String() + "@buyer.test",
+		Password:      "password",
+		WalletAddress: crypto.PubkeyToAddress(h.buyerKey.PublicKey).Hex(),
+	}
+	seller := db.Users{
+		ID:            uuid.Must(uuid.NewV4()),
+		FirstName:     "Seller",
+		LastName:      "Test",
+		Email:         uuid.Must(uuid.NewV4()).String() + "@seller.test",
+		Password:      "password",
+		WalletAddress: crypto.PubkeyToAddress(h.sellerKey.PublicKey).Hex(),
+	}
+	store := db.Stores{ID: uuid.Must(uuid.NewV4()), Name: "Store", OwnerID: seller.ID}
+	product := db.Products{
+		ID:          uuid.Must(uuid.NewV4()),
+		Name:        "Product",
+		ImageURL:    "product.png",
+		Price:       total,
+		Unit:        "pcs",
+		Description: "Product",
+		StoreID:     store.ID,
+		ChainID:     c.network.ChainID,
+	}
+
+	header, err := c.backend.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		h.t.Fatalf("reading head: %v", err)
+	}
+	releaseTime := time.Unix(int64(header.Time), 0).Add(h.escrow.ReleaseDelay())
+
+	order := db.Orders{
+		ID:          uuid.Must(uuid.NewV4()),
+		ProductID:   product.ID,
+		BuyerID:     buyer.ID,
+		Quantity:    1,
+		Total:       total,
+		Status:      db.OrderStatusPending,
+		ReleaseTime: &releaseTime,
+		ChainID:     c.network.ChainID,
+	}
+
+	for _, value := range []interface{}{&buyer, &seller, &store, &product, &order} {
+		err := h.db.Create(value).Error
+		if err != nil {
+			h.t.Fatalf("seeding %T: %v", value, err)
+		}
+	}
+
+	return order
+}
+
+// createTokenOrder stores a pending order on the default network priced in a whitelisted token
+func (h *harness) createTokenOrder(symbol string, total money.Amount) db.Orders {
+	h.t.Helper()
+
+	token := h.token(h.chains[0], symbol)
+	order := h.createOrder(total)
+	order.Token = token.Address
+
+	err := h.db.Model(&db.Products{}).Where("id = ?", order.ProductID).Updates(map[string]interface{}{"unit": token.Symbol, "token": token.Address}).Error
+	if err == nil {
+		err = h.db.Model(&db.Orders{}).Where("id = ?", order.ID).Update("token", token.Address).Error
+	}
+	if err != nil {
+		h.t.Fatalf("pricing order in %s: %v", symbol, err)
+	}
+
+	return order
+}
+
+// token returns a token whitelisted on a network
+func (h *harness) token(c *chain, symbol string) config.TokenConfig {
+	h.t.Helper()
+
+	token, err := h.escrow.Token(c.network.ChainID, symbol)
+	if err != nil {
+		h.t.Fatalf("finding %s: %v", symbol, err)
+	}
+
+	return token
+}
+
+// deposit sends the buyer's createOrder transaction for an order with the given value
+// to the order's network and mines it
+func (h *harness) deposit(order db.Orders, value money.Amount) *types.Receipt {
+	h.t.Helper()
+
+	return h.depositOn(h.chain(order.ChainID), order, value)
+}
+
+// depositOn funds an order through the escrow of any network
+func (h *harness) depositOn(c *chain, order db.Orders, value money.Amount) *types.Receipt {
+	h.t.Helper()
+
+	calldata, err := h.escrow.CreateOrderCalldata(order.ID, crypto.PubkeyToAddress(h.sellerKey.PublicKey), *order.ReleaseTime)
+	if err != nil {
+		h.t.Fatalf("encoding createOrder: %v", err)
+	}
+
+	opts := transactor(h.t, h.buyerKey)
+	opts.Value = value.Big()
+
+	return h.transact(c, opts, calldata)
+}
+
+// depositToken approves the escrow for an amount of a token and sends the buyer's
+// createTokenOrder transaction for an order paying with it
+func (h *harness) depositToken(order db.Orders, symbol string, amount money.Amount) *types.Receipt {
+	h.t.Helper()
+
+	c := h.chain(order.ChainID)
+	token := common.HexToAddress(h.token(c, symbol).Address)
+	escrowAddress := common.HexToAddress(c.network.EscrowAddress)
+
+	approve, err := h.escrow.ApproveCalldata(escrowAddress, amount.Big())
+	if err != nil {
+		h.t.Fatalf("encoding approve: %v", err)
+	}
+	h.send(c, token, transactor(h.t, h.buyerKey), approve)
+
+	calldata, err := h.escrow.CreateTokenOrderCalldata(order.ID, crypto.PubkeyToAddress(h.sellerKey.PublicKey), token, amount.Big(), *order.ReleaseTime)
+	if err != nil {
+		h.t.Fatalf("encoding createTokenOrder: %v", err)
+	}
+
+	return h.send(c, escrowAddress, transactor(h.t, h.buyerKey), calldata)
+}
+
+// tokenBalance reads an account's balance of a token on the default network
+func (h *harness) tokenBalance(symbol string, account common.Address) *big.Int {
+	h.t.Helper()
+
+	token := common.HexToAddress(h.token(h.chains[0], symbol).Address)
+	calldata, err := h.escrow.TokenABI().Pack("balanceOf", account)
+	if err != nil {
+		h.t.Fatalf("encoding balanceOf: %v", err)
+	}
+
+	out, err := h.backend.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: calldata}, nil)
+	if err != nil {
+		h.t.Fatalf("reading %s balance: %v", symbol, err)
+	}
+
+	return new(big.Int).SetBytes(out)
+}
+
+// claim sends the seller's claimOrders transaction and mines it
+func (h *harness) claim(orders ...db.Orders) *types.Receipt {
+	h.t.Helper()
+
+	ids := make([]uuid.UUID, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+
+	calldata, err := h.escrow.ClaimOrdersCalldata(ids)
+	if err != nil {
+		h.t.Fatalf("encoding claimOrders: %v", err)
+	}
+
+	return h.transact(h.chain(orders[0].ChainID), transactor(h.t, h.sellerKey), calldata)
+}
+
+// transact sends a transaction to a network's escrow and mines it
+func (h *harness) transact(c *chain, opts *bind.TransactOpts, calldata []byte) *types.Receipt {
+	h.t.Helper()
+
+	return h.send(c, common.HexToAddress(c.network.EscrowAddress), opts, calldata)
+}
+
+// send sends a transaction to any contract and mines it
+func (h *harness) send(c *chain, address common.Address, opts *bind.TransactOpts, calldata []byte) *types.Receipt {
+	h.t.Helper()
+
+	contract := bind.NewBoundContract(address, abi.ABI{}, c.backend, c.backend, c.backend)
+	tx, err := contract.RawTransact(opts, calldata)
+	if err != nil {
+		h.t.Fatalf("sending transaction: %v", err)
+	}
+	c.backend.Commit()
+
+	receipt, err := c.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		h.t.Fatalf("reading receipt: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		h.t.Fatalf("transaction %s reverted", tx.Hash().Hex())
+	}
+
+	return receipt
+}
+
+// mine commits empty blocks
+func (h *harness) mine(blocks int) {
+	for i := 0; i < blocks; i++ {
+		h.backend.Commit()
+	}
+}
+
+// advance moves the chain clock forward and mines a block at the new time
+func (h *harness) advance(d time.Duration) {
+	h.t.Helper()
+
+	err := h.backend.AdjustTime(d)
+	if err != nil {
+		h.t.Fatalf("adjusting time: %v", err)
+	}
+	h.backend.Commit()
+}
+
+func (h *harness) order(id uuid.UUID) db.Orders {
+	h.t.Helper()
+
+	var order db.Orders
+	err := h.db.Where("id = ?", id).First(&order).Error
+	if err != nil {
+		h.t.Fatalf("loading order %s: %v", id, err)
+	}
+
+	return order
+}
+
+// waitForStatus waits until the observer moved an order to the given status
+func (h *harness) waitForStatus(id uuid.UUID, status db.OrderStatus) db.Orders {
+	h.t.Helper()
+
+	h.eventually(fmt.Sprintf("order %s %s", id, status), func() bool {
+		return h.order(id).Status == status
+	})
+
+	return h.order(id)
+}
+
+func (h *harness) eventually(what string, condition func() bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// wei converts a decimal ether amount to wei
+func wei(t *testing.T, ether string) money.Amount {
+	t.Helper()
+
+	amount, err := parseUnits(ether, money.EtherDecimals)
+	if err != nil {
+		t.Fatalf("converting %s ETH: %v", ether, err)
+	}
+
+	return amount
+}
+
+// tokenUnits converts a decimal amount of a simulated token to its base units
+func tokenUnits(t *testing.T, value string) money.Amount {
+	t.Helper()
+
+	amount, err := parseUnits(value, tokenDecimals)
+	if err != nil {
+		t.Fatalf("converting %s tokens: %v", value, err)
+	}
+
+	return amount
+}
+
+// parseUnits reads a decimal amount of whole currency, such as "0.25" ether, as base units
+func parseUnits(s string, decimals int) (money.Amount, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > decimals {
+		return money.Amount{}, fmt.Errorf("%q has more than %d decimals", s, decimals)
+	}
+
+	return money.Parse(whole + frac + strings.Repeat("0", decimals-len(frac)))
+}
+
+// history returns the recorded transitions of an order as "from->to" pairs
+func (h *harness) history(id uuid.UUID) string {
+	h.t.Helper()
+
+	var rows []db.OrderStatusHistory
+	err := h.db.Where("order_id = ?", id).Order("created_at asc").Find(&rows).Error
+	if err != nil {
+		h.t.Fatalf("loading history: %v", err)
+	}
+
+	transitions := make([]string, len(rows))
+	for i, row := range rows {
+		transitions[i] = fmt.Sprintf("%s->%s", row.FromStatus, row.ToStatus)
+	}
+
+	return strings.Join(transitions, ",")
+}
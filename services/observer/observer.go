@@ -2,38 +2,85 @@ package observer
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"strings"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
 	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/TechXTT/bazaar-backend/services/web"
 	"github.com/TechXTT/bazaar-backend/services/wsclient"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
 	"github.com/mikestefanello/hooks"
 	"github.com/samber/do"
+	"gorm.io/gorm"
 )
 
 type (
 	// Observer is the observer service interface
 	Observer interface {
-		// SubscribeToEvents subscribes to events
-		SubscribeToEvents(contractAddress common.Address, logs chan<- types.Log, contractABI abi.ABI) (ethereum.Subscription, error)
 		// UpdateOrderStatus updates the order status
 		UpdateOrderStatus(orderID string, status string) error
-		// RunSubscription runs the subscription
-		RunSubscription(contractABIPath string) error
+		// VerifyTransaction checks a buyer submitted escrow deposit on a network and applies it without waiting for confirmations
+		VerifyTransaction(chainID uint64, orderID string, txHash common.Hash) error
+		// Run supervises one subscription per network, reconnecting each with backoff whenever it stops
+		Run()
+		// Status returns the current state of each network's subscription
+		Status() []Status
 	}
 
+	// chainClient is the part of the ethereum client the observer relies on
+	chainClient interface {
+		ethereum.LogFilterer
+		HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+		SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+		TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	}
+
+	// observers runs a worker for each configured network
+	observers struct {
+		orderState orderstate.OrderState
+		workers    []*observer
+	}
+
+	// observer watches the escrow contract of a single network
 	observer struct {
-		cfg      config.Config
-		wsClient ethclient.Client
+		cfg     config.Config
+		network config.NetworkConfig
+		// dial connects a chain client and returns the function closing it
+		dial     func() (chainClient, func(), error)
+		wsClient chainClient
 		db       db.DB
+
+		orderState orderstate.OrderState
+		bus        events.Bus
+
+		contractAddress common.Address
+		contractABI     abi.ABI
+		tokenABI        abi.ABI
+		// events maps the topic of each escrow event to its ABI definition and handler
+		events map[common.Hash]registeredEvent
+
+		// pending holds logs waiting for enough confirmations, keyed on tx hash and log index
+		pending map[logKey]types.Log
+
+		mu     sync.RWMutex
+		status Status
 	}
 )
 
@@ -42,26 +89,117 @@ func init() {
 	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
 		do.Provide(e.Msg, NewObserver)
 	})
+
+	// Expose the subscription state during router build
+	web.HookBuildRouter.Listen(func(e hooks.Event[*mux.Router]) {
+		o := do.MustInvoke[Observer](do.DefaultInjector)
+
+		e.Msg.HandleFunc("/health/observer", func(w http.ResponseWriter, r *http.Request) {
+			statuses := o.Status()
+
+			w.Header().Set("Content-Type", "application/json")
+			for _, status := range statuses {
+				if status.State != StateConnected {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					break
+				}
+			}
+			json.NewEncoder(w).Encode(statuses)
+		}).Methods(http.MethodGet)
+	})
 }
 
 func NewObserver(i *do.Injector) (Observer, error) {
 	wsClient := do.MustInvoke[wsclient.WsClient](i)
 	db := do.MustInvoke[db.DB](i)
 	cfg := do.MustInvoke[config.Config](i)
-	return &observer{
-		wsClient: *wsClient.InitEthClient(),
-		db:       db,
-		cfg:      cfg,
-	}, nil
+	escrow := do.MustInvoke[escrow.Escrow](i)
+	orderState := do.MustInvoke[orderstate.OrderState](i)
+	bus := do.MustInvoke[events.Bus](i)
+
+	handlers, err := registerEvents(escrow.ABI())
+	if err != nil {
+		return nil, fmt.Errorf("validating escrow ABI: %w", err)
+	}
+
+	o := &observers{orderState: orderState}
+	for _, network := range escrow.Networks() {
+		chainID := network.ChainID
+
+		o.workers = append(o.workers, &observer{
+			cfg:     cfg,
+			network: network,
+			dial: func() (chainClient, func(), error) {
+				client, err := wsClient.Dial(chainID)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				return client, client.Close, nil
+			},
+			db:              db,
+			orderState:      orderState,
+			bus:             bus,
+			contractAddress: common.HexToAddress(network.EscrowAddress),
+			contractABI:     escrow.ABI(),
+			tokenABI:        escrow.TokenABI(),
+			events:          handlers,
+			status:          Status{ChainID: chainID, Network: network.Name, State: StateStopped, Since: time.Now()},
+		})
+	}
+
+	return o, nil
+}
+
+func (o *observers) UpdateOrderStatus(orderID string, status string) error {
+	return o.orderState.Transition(uuid.FromStringOrNil(orderID), db.OrderStatus(status), escrowActor, orderstate.SourceChain)
+}
+
+func (o *observers) VerifyTransaction(chainID uint64, orderID string, txHash common.Hash) error {
+	worker, ok := o.worker(chainID)
+	if !ok {
+		return escrow.ErrUnknownNetwork
+	}
+
+	return worker.VerifyTransaction(orderID, txHash)
 }
 
-func (o *observer) SubscribeToEvents(contractAddress common.Address, logs chan<- types.Log, contractABI abi.ABI) (ethereum.Subscription, error) {
+func (o *observers) Run() {
+	var wg sync.WaitGroup
+	for _, worker := range o.workers {
+		wg.Add(1)
+		go func(worker *observer) {
+			defer wg.Done()
+			worker.Run()
+		}(worker)
+	}
+
+	wg.Wait()
+}
 
-	query := ethereum.FilterQuery{
-		Addresses: []common.Address{contractAddress},
-		Topics:    [][]common.Hash{{contractABI.Events["OrderCompleted"].ID, contractABI.Events["OrderRefunded"].ID, contractABI.Events["OrderReleased"].ID}},
+func (o *observers) Status() []Status {
+	statuses := make([]Status, len(o.workers))
+	for i, worker := range o.workers {
+		statuses[i] = worker.Status()
 	}
 
+	return statuses
+}
+
+func (o *observers) worker(chainID uint64) (*observer, bool) {
+	for _, worker := range o.workers {
+		if worker.network.ChainID == chainID {
+			return worker, true
+		}
+	}
+
+	return nil, false
+}
+
+func (o *observer) SubscribeToEvents(logs chan<- types.Log) (ethereum.Subscription, error) {
+
+	query := o.filterQuery()
+
 	subscription, err := o.wsClient.SubscribeFilterLogs(context.Background(), query, logs)
 	if err != nil {
 		return nil, err
@@ -70,79 +208,149 @@ func (o *observer) SubscribeToEvents(contractAddress common.Address, logs chan<-
 	return subscription, nil
 }
 
-func (o *observer) UpdateOrderStatus(orderID string, status string) error {
-	db := o.db.DB()
+func (o *observer) filterQuery() ethereum.FilterQuery {
+	topics := make([]common.Hash, 0, len(o.events))
+	for topic := range o.events {
+		topics = append(topics, topic)
+	}
+
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{o.contractAddress},
+		Topics:    [][]common.Hash{topics},
+	}
+}
+
+func (o *observer) applyStatus(tx *gorm.DB, orderID string, status db.OrderStatus) (*statusChange, error) {
+	var order db.Orders
+	result := tx.Where("id = ?", orderID).Limit(1).Find(&order)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Order %s not found, skipping status %s", orderID, status)
+		return nil, nil
+	}
+	if order.ChainID != o.network.ChainID {
+		log.Printf("Order %s settles on chain %d, skipping status %s from chain %d", orderID, order.ChainID, status, o.network.ChainID)
+		return nil, nil
+	}
 
-	db.Exec("UPDATE orders SET status = $1 WHERE id = $2", status, orderID)
+	err := o.orderState.TransitionTx(tx, order.ID, status, escrowActor, orderstate.SourceChain)
+	if errors.Is(err, orderstate.ErrIllegalTransition) {
+		log.Printf("Order %s not moved by escrow event: %v", orderID, err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return &statusChange{
+		OrderID:             orderID,
+		PreviousStatus:      order.Status,
+		Status:              status,
+		PreviousTxHash:      order.TxHash,
+		PreviousReleaseTime: order.ReleaseTime,
+	}, nil
 }
 
-func (o *observer) RunSubscription(contractABIPath string) error {
+func (o *observer) RunSubscription() error {
 
 	logs := make(chan types.Log)
-	contractAddress := common.HexToAddress(o.cfg.GetWs().ContractAddress)
 
-	fileBytes, err := os.ReadFile(contractABIPath)
+	client, closeClient, err := o.dial()
 	if err != nil {
-		log.Println("Error reading contract ABI file")
+		log.Println("Error connecting to ethereum client")
 		return err
 	}
+	defer closeClient()
+	o.wsClient = client
 
-	fileContents := string(fileBytes)
+	mode := o.mode()
+	if mode == ModePoll {
+		return o.runPolling()
+	}
+
+	// Subscribe before backfilling so no log is missed in between, duplicates are skipped
+	subscription, err := o.SubscribeToEvents(logs)
+	if errors.Is(err, rpc.ErrNotificationsUnsupported) && mode == ModeAuto {
+		log.Println("Endpoint does not support subscriptions, falling back to polling")
+		return o.runPolling()
+	}
+	if err != nil {
+		log.Println("Error subscribing to events")
+		return err
+	}
+	defer subscription.Unsubscribe()
 
-	contractABI, err := abi.JSON(strings.NewReader(fileContents))
+	heads := make(chan *types.Header)
+	headSubscription, err := o.wsClient.SubscribeNewHead(context.Background(), heads)
 	if err != nil {
-		log.Println("Error parsing contract ABI")
+		log.Println("Error subscribing to new heads")
 		return err
 	}
+	defer headSubscription.Unsubscribe()
 
-	subscription, err := o.SubscribeToEvents(contractAddress, logs, contractABI)
+	head, err := o.backfill()
 	if err != nil {
-		log.Println("Error subscribing to events")
+		log.Println("Error backfilling events")
 		return err
 	}
-	defer subscription.Unsubscribe()
+
+	o.setStatus(StateConnected, nil)
+	o.setMode(ModeSubscribe)
 
 	for {
 		select {
 		case err := <-subscription.Err():
 			log.Println("Subscription error", err)
 			return err
-		case vLog := <-logs:
+		case err := <-headSubscription.Err():
+			log.Println("Head subscription error", err)
+			return err
+		case header := <-heads:
+			if header.Number.Uint64() > head {
+				head = header.Number.Uint64()
+			}
 
-			eventMap := make(map[string]interface{})
-			err := contractABI.UnpackIntoMap(eventMap, "OrderCompleted", vLog.Data)
+			err := o.applyConfirmed(head, head)
 			if err != nil {
-				log.Println("Error unpacking event", err)
 				return err
 			}
-
-			data := eventMap["orderId"].([32]uint8)
-
-			orderId := string(data[:])
-			formattedOrderId := strings.Join([]string{orderId[:8], orderId[8:12], orderId[12:16], orderId[16:20], orderId[20:]}, "-")
-
-			switch vLog.Topics[0].Hex() {
-			case contractABI.Events["OrderCompleted"].ID.Hex():
-				err := o.UpdateOrderStatus(formattedOrderId, "completed")
-				if err != nil {
-					log.Println("Error updating order status")
-					return err
-				}
-			case contractABI.Events["OrderRefunded"].ID.Hex():
-				err := o.UpdateOrderStatus(formattedOrderId, "cancelled")
-				if err != nil {
-					log.Println("Error updating order status")
-					return err
-				}
-			case contractABI.Events["OrderReleased"].ID.Hex():
-				err := o.UpdateOrderStatus(formattedOrderId, "released")
+		case vLog := <-logs:
+			if vLog.Removed {
+				err := o.removeLog(vLog)
 				if err != nil {
-					log.Println("Error updating order status")
 					return err
 				}
+				continue
+			}
+
+			o.pending[logKey{vLog.TxHash, vLog.Index}] = vLog
+
+			err := o.applyConfirmed(head, head)
+			if err != nil {
+				return err
 			}
 		}
 	}
 }
+
+func (o *observer) handleLog(tx *gorm.DB, client chainClient, vLog types.Log) (*statusChange, error) {
+	if len(vLog.Topics) == 0 {
+		return nil, nil
+	}
+
+	event, ok := o.events[vLog.Topics[0]]
+	if !ok {
+		return nil, nil
+	}
+
+	// A log that cannot be decoded will never succeed, so it is skipped instead of stopping the observer
+	values, err := decodeEvent(event.Event, vLog)
+	if err != nil {
+		log.Printf("Error decoding %s event: %v", event.Event.Name, err)
+		return nil, nil
+	}
+
+	return event.Handle(o, tx, client, vLog, values)
+}
@@ -0,0 +1,65 @@
+package observer
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ModeAuto      = "auto"
+	ModeSubscribe = "subscribe"
+	ModePoll      = "poll"
+
+	defaultPollInterval = 15 * time.Second
+)
+
+// mode resolves the configured observer mode, in auto mode plain HTTP endpoints
+// are polled since they cannot serve eth_subscribe
+func (o *observer) mode() string {
+	switch strings.ToLower(o.cfg.GetWs().ObserverMode) {
+	case ModeSubscribe:
+		return ModeSubscribe
+	case ModePoll:
+		return ModePoll
+	}
+
+	u, err := url.Parse(o.network.RPCURL)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return ModePoll
+	}
+
+	return ModeAuto
+}
+
+// runPolling fetches new logs with eth_getLogs on every interval, going through the
+// same cursor, confirmation and handler pipeline as the subscription
+func (o *observer) runPolling() error {
+	interval := o.cfg.GetWs().PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	_, err := o.backfill()
+	if err != nil {
+		log.Println("Error backfilling events")
+		return err
+	}
+
+	o.setStatus(StateConnected, nil)
+	o.setMode(ModePoll)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := o.backfill()
+		if err != nil {
+			log.Println("Error polling events", err)
+			return err
+		}
+	}
+
+	return nil
+}
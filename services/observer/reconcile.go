@@ -0,0 +1,212 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/TechXTT/bazaar-backend/services/orderstate"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+func (o *observer) handleOrderCreated(tx *gorm.DB, client chainClient, vLog types.Log, values map[string]interface{}) (*statusChange, error) {
+	return o.reconcileDeposit(tx, vLog, values, common.Address{}, values["amount"].(*big.Int))
+}
+
+// handleTokenOrderCreated checks a token deposit against the tokens the escrow actually
+// received, which is less than the event amount for tokens taking a fee on transfer
+func (o *observer) handleTokenOrderCreated(tx *gorm.DB, client chainClient, vLog types.Log, values map[string]interface{}) (*statusChange, error) {
+	token := values["token"].(common.Address)
+
+	received, err := o.tokensReceived(client, vLog, token, values["buyer"].(common.Address))
+	if err != nil {
+		return nil, err
+	}
+
+	return o.reconcileDeposit(tx, vLog, values, token, received)
+}
+
+// tokensReceived sums the token transfers from the buyer to the escrow made for a deposit,
+// those since the previous escrow log of its transaction, so a batch of deposits is split up
+func (o *observer) tokensReceived(client chainClient, vLog types.Log, token common.Address, buyer common.Address) (*big.Int, error) {
+	receipt, err := client.TransactionReceipt(context.Background(), vLog.TxHash)
+	if err != nil {
+		return nil, err
+	}
+
+	transferEvent := o.tokenABI.Events["Transfer"]
+
+	received := new(big.Int)
+	for _, transfer := range receipt.Logs {
+		if transfer.Index >= vLog.Index {
+			break
+		}
+		if transfer.Address == o.contractAddress {
+			received = new(big.Int)
+			continue
+		}
+		if transfer.Address != token || len(transfer.Topics) == 0 || transfer.Topics[0] != transferEvent.ID {
+			continue
+		}
+
+		values, err := decodeEvent(transferEvent, *transfer)
+		if err != nil || values["from"].(common.Address) != buyer || values["to"].(common.Address) != o.contractAddress {
+			continue
+		}
+
+		received.Add(received, values["value"].(*big.Int))
+	}
+
+	return received, nil
+}
+
+// reconcileDeposit checks a deposit of an amount of the native currency, or of a token
+// when one is given, against its order and marks the order as paid when it matches
+func (o *observer) reconcileDeposit(tx *gorm.DB, vLog types.Log, values map[string]interface{}, token common.Address, amount *big.Int) (*statusChange, error) {
+	orderId := escrow.FormatOrderID(values["orderId"].([32]byte))
+	buyer := values["buyer"].(common.Address)
+	receiver := values["receiver"].(common.Address)
+	txHash := vLog.TxHash.Hex()
+
+	var order db.Orders
+	result := tx.Preload("Buyer").Preload("Product.Store.Owner").Where("id = ?", orderId).First(&order)
+	if result.Error != nil {
+		return nil, o.flagMismatches(tx, txHash, []db.Reconciliations{{
+			OrderID:  uuid.FromStringOrNil(orderId),
+			Field:    "order",
+			Expected: orderId,
+			Actual:   "not found",
+		}})
+	}
+
+	change := &statusChange{
+		OrderID:             orderId,
+		PreviousStatus:      order.Status,
+		Status:              order.Status,
+		PreviousTxHash:      order.TxHash,
+		PreviousReleaseTime: order.ReleaseTime,
+	}
+
+	// A deposit on another network never pays the order, whatever it holds
+	if order.ChainID != o.network.ChainID {
+		return change, o.flagMismatches(tx, txHash, []db.Reconciliations{{
+			OrderID:  order.ID,
+			Field:    "chain_id",
+			Expected: strconv.FormatUint(order.ChainID, 10),
+			Actual:   strconv.FormatUint(o.network.ChainID, 10),
+		}})
+	}
+
+	var mismatches []db.Reconciliations
+
+	// An empty order token and the zero address both stand for the native currency
+	if common.HexToAddress(order.Token) != token {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "token",
+			Expected: order.Token,
+			Actual:   token.Hex(),
+		})
+	}
+
+	if order.Total.Cmp(amount) != 0 {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "amount",
+			Expected: order.Total.String(),
+			Actual:   amount.String(),
+		})
+	}
+
+	owner := order.Product.Store.Owner
+	if !common.IsHexAddress(owner.WalletAddress) || common.HexToAddress(owner.WalletAddress) != receiver {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "receiver",
+			Expected: owner.WalletAddress,
+			Actual:   receiver.Hex(),
+		})
+	}
+
+	// Buyers are not required to register a wallet, so only a registered one is checked
+	if order.Buyer.WalletAddress != "" && common.HexToAddress(order.Buyer.WalletAddress) != buyer {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "buyer",
+			Expected: order.Buyer.WalletAddress,
+			Actual:   buyer.Hex(),
+		})
+	}
+
+	// The release time chosen at order creation must be the one the buyer deposited with
+	releaseTime := time.Unix(values["releaseTime"].(*big.Int).Int64(), 0)
+	if order.ReleaseTime != nil && order.ReleaseTime.Unix() != releaseTime.Unix() {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "release_time",
+			Expected: strconv.FormatInt(order.ReleaseTime.Unix(), 10),
+			Actual:   strconv.FormatInt(releaseTime.Unix(), 10),
+		})
+	}
+
+	// The first matching deposit seen for an order is kept, a later one is only flagged
+	if order.TxHash != "" && order.TxHash != txHash {
+		mismatches = append(mismatches, db.Reconciliations{
+			Field:    "tx_hash",
+			Expected: order.TxHash,
+			Actual:   txHash,
+		})
+	}
+
+	if len(mismatches) > 0 {
+		for i := range mismatches {
+			mismatches[i].OrderID = order.ID
+		}
+
+		return change, o.flagMismatches(tx, txHash, mismatches)
+	}
+
+	// A mismatched deposit never pins its hash, or anyone could block the buyer's real one
+	if order.TxHash == "" {
+		result = tx.Exec("UPDATE orders SET tx_hash = $1, release_time = $2 WHERE id = $3", txHash, releaseTime, orderId)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	}
+
+	err := o.orderState.TransitionTx(tx, order.ID, db.OrderStatusPaid, escrowActor, orderstate.SourceChain)
+	if errors.Is(err, orderstate.ErrIllegalTransition) {
+		log.Printf("Order %s not marked as paid: %v", orderId, err)
+		return change, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change.Status = db.OrderStatusPaid
+	return change, nil
+}
+
+func (o *observer) flagMismatches(tx *gorm.DB, txHash string, mismatches []db.Reconciliations) error {
+	for i := range mismatches {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		mismatches[i].ID = id
+		mismatches[i].ChainID = o.network.ChainID
+		mismatches[i].TxHash = txHash
+		log.Printf("Reconciliation mismatch for order %s on %s: expected %s, got %s", mismatches[i].OrderID, mismatches[i].Field, mismatches[i].Expected, mismatches[i].Actual)
+	}
+
+	result := tx.Create(&mismatches)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package observer
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	StateStopped    = "stopped"
+	StateConnecting = "connecting"
+	StateConnected  = "connected"
+	StateRetrying   = "retrying"
+
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// Status describes the state of a network's escrow subscription
+type Status struct {
+	ChainID     uint64    `json:"chain_id"`
+	Network     string    `json:"network,omitempty"`
+	State       string    `json:"state"`
+	Mode        string    `json:"mode,omitempty"`
+	Since       time.Time `json:"since"`
+	Retries     int       `json:"retries"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+func (o *observer) Run() {
+	minBackoff := o.cfg.GetWs().ReconnectMinBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+	maxBackoff := o.cfg.GetWs().ReconnectMaxBackoff
+	if maxBackoff < minBackoff {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	backoff := minBackoff
+	for {
+		o.setStatus(StateConnecting, nil)
+
+		started := time.Now()
+		err := o.RunSubscription()
+
+		// A subscription that stayed up for a while starts over with the shortest delay
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+
+		o.setStatus(StateRetrying, err)
+		log.Printf("Observer stopped: %v, reconnecting in %s", err, backoff)
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (o *observer) Status() Status {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.status
+}
+
+func (o *observer) setMode(mode string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.status.Mode = mode
+}
+
+func (o *observer) setStatus(state string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+
+	if state != o.status.State {
+		o.status.State = state
+		o.status.Since = now
+	}
+
+	switch state {
+	case StateConnected:
+		o.status.Retries = 0
+	case StateRetrying:
+		o.status.Retries++
+	}
+
+	if err != nil {
+		o.status.LastError = err.Error()
+		o.status.LastErrorAt = now
+	}
+}
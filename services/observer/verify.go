@@ -0,0 +1,84 @@
+package observer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/escrow"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransactionFailed   = errors.New("transaction failed")
+	ErrDepositNotFound     = errors.New("transaction does not fund this order")
+	ErrDepositMismatch     = errors.New("deposit does not match the order and was flagged for review")
+)
+
+func (o *observer) VerifyTransaction(orderID string, txHash common.Hash) error {
+	client, closeClient, err := o.dial()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	receipt, err := client.TransactionReceipt(context.Background(), txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return ErrTransactionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return ErrTransactionFailed
+	}
+
+	depositEvents := map[common.Hash]abi.Event{}
+	for _, name := range []string{"OrderCreated", "TokenOrderCreated"} {
+		event, ok := o.contractABI.Events[name]
+		if ok {
+			depositEvents[event.ID] = event
+		}
+	}
+
+	for _, vLog := range receipt.Logs {
+		if vLog.Address != o.contractAddress || len(vLog.Topics) == 0 {
+			continue
+		}
+
+		createdEvent, ok := depositEvents[vLog.Topics[0]]
+		if !ok {
+			continue
+		}
+
+		values, err := decodeEvent(createdEvent, *vLog)
+		if err != nil || escrow.FormatOrderID(values["orderId"].([32]byte)) != orderID {
+			continue
+		}
+
+		// Recorded like any observed log, so the observer skips it later and a reorg still reverts it
+		err = o.processLog(client, *vLog)
+		if err != nil {
+			return err
+		}
+
+		var order db.Orders
+		result := o.db.DB().Where("id = ?", orderID).First(&order)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if order.Status == db.OrderStatusPending || order.TxHash != txHash.Hex() {
+			return ErrDepositMismatch
+		}
+
+		return nil
+	}
+
+	return ErrDepositNotFound
+}
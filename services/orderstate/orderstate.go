@@ -0,0 +1,195 @@
+package orderstate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+)
+
+// Source identifies what triggered a status change
+type Source string
+
+const (
+	SourceAPI   Source = "api"
+	SourceChain Source = "chain"
+	SourceJob   Source = "job"
+)
+
+var (
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	ErrStatusChanged     = errors.New("order status changed concurrently")
+)
+
+// transitions lists the statuses each status can move to, statuses without an
+// entry are final
+var transitions = map[db.OrderStatus][]db.OrderStatus{
+	db.OrderStatusPending: {db.OrderStatusPaid, db.OrderStatusCancelled},
+	db.OrderStatusPaid:    {db.OrderStatusShipped, db.OrderStatusCompleted, db.OrderStatusReleased, db.OrderStatusRefunded},
+	db.OrderStatusShipped: {db.OrderStatusCompleted, db.OrderStatusReleased, db.OrderStatusRefunded},
+}
+
+type (
+	// OrderState is the order status state machine
+	OrderState interface {
+		// Transition moves an order to a new status and records it in the history
+		Transition(orderID uuid.UUID, to db.OrderStatus, actor string, source Source) error
+
+		// TransitionTx moves an order to a new status as part of a transaction, the caller
+		// notifies the event bus once it committed
+		TransitionTx(tx *gorm.DB, orderID uuid.UUID, to db.OrderStatus, actor string, source Source) error
+
+		// Revert moves an order back to a previous status, bypassing the transition rules,
+		// it is only meant to undo a change whose cause disappeared, such as a reorged log
+		Revert(orderID uuid.UUID, from db.OrderStatus, to db.OrderStatus, actor string, source Source) error
+
+		// RevertTx moves an order back to a previous status as part of a transaction, the
+		// caller notifies the event bus once it committed
+		RevertTx(tx *gorm.DB, orderID uuid.UUID, from db.OrderStatus, to db.OrderStatus, actor string, source Source) error
+
+		// History returns the status changes of an order, oldest first
+		History(orderID uuid.UUID) ([]db.OrderStatusHistory, error)
+	}
+
+	orderState struct {
+		db     db.DB
+		events events.Bus
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewOrderState)
+	})
+}
+
+func NewOrderState(i *do.Injector) (OrderState, error) {
+	return &orderState{
+		db:     do.MustInvoke[db.DB](i),
+		events: do.MustInvoke[events.Bus](i),
+	}, nil
+}
+
+// CanTransition reports whether an order may move between two statuses
+func CanTransition(from db.OrderStatus, to db.OrderStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *orderState) Transition(orderID uuid.UUID, to db.OrderStatus, actor string, source Source) error {
+	err := s.db.DB().Transaction(func(tx *gorm.DB) error {
+		return s.TransitionTx(tx, orderID, to, actor, source)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.events.Notify()
+	return nil
+}
+
+func (s *orderState) TransitionTx(tx *gorm.DB, orderID uuid.UUID, to db.OrderStatus, actor string, source Source) error {
+	var order db.Orders
+	result := tx.Where("id = ?", orderID).Limit(1).Find(&order)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderNotFound
+	}
+
+	if !CanTransition(order.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, order.Status, to)
+	}
+
+	err := s.apply(tx, orderID, order.Status, to, actor, source)
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case db.OrderStatusPaid:
+		return s.events.Record(tx, events.OrderPaid{OrderID: orderID, TxHash: order.TxHash})
+	case db.OrderStatusCompleted:
+		return s.events.Record(tx, events.OrderCompleted{OrderID: orderID})
+	}
+
+	return nil
+}
+
+func (s *orderState) Revert(orderID uuid.UUID, from db.OrderStatus, to db.OrderStatus, actor string, source Source) error {
+	err := s.db.DB().Transaction(func(tx *gorm.DB) error {
+		return s.RevertTx(tx, orderID, from, to, actor, source)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.events.Notify()
+	return nil
+}
+
+func (s *orderState) RevertTx(tx *gorm.DB, orderID uuid.UUID, from db.OrderStatus, to db.OrderStatus, actor string, source Source) error {
+	return s.apply(tx, orderID, from, to, actor, source)
+}
+
+func (s *orderState) History(orderID uuid.UUID) ([]db.OrderStatusHistory, error) {
+	var history []db.OrderStatusHistory
+
+	result := s.db.DB().Where("order_id = ?", orderID).Order("created_at asc").Find(&history)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return history, nil
+}
+
+// apply updates the status only if it is still the expected one, so concurrent
+// changes cannot skip the transition rules
+func (s *orderState) apply(tx *gorm.DB, orderID uuid.UUID, from db.OrderStatus, to db.OrderStatus, actor string, source Source) error {
+	result := tx.Exec("UPDATE orders SET status = $1 WHERE id = $2 AND status = $3", to, orderID, from)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Create(&db.OrderStatusHistory{
+		ID:         id,
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor,
+		Source:     string(source),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return s.events.Record(tx, events.OrderStatusChanged{
+		OrderID: orderID,
+		From:    from,
+		To:      to,
+		Actor:   actor,
+		Source:  string(source),
+	})
+}
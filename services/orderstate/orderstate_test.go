@@ -0,0 +1,195 @@
+package orderstate
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/events"
+	"github.com/glebarez/sqlite"
+	"github.com/gofrs/uuid/v5"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDB struct {
+	db *gorm.DB
+}
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+// newOrderState returns a state machine over a fresh database holding one order
+// in the given status
+func newOrderState(t *testing.T, status db.OrderStatus) (*orderState, *gorm.DB, uuid.UUID) {
+	t.Helper()
+
+	// Concurrent writers wait for the lock instead of failing with SQLITE_BUSY
+	dsn := filepath.Join(t.TempDir(), "orderstate.db") + "?_pragma=busy_timeout(5000)"
+	database, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	db.Migrate(database)
+
+	i := do.New()
+	do.ProvideValue[db.DB](i, &testDB{db: database})
+	do.Provide(i, events.NewBus)
+
+	s, err := NewOrderState(i)
+	if err != nil {
+		t.Fatalf("creating order state: %v", err)
+	}
+
+	buyer := db.Users{ID: uuid.Must(uuid.NewV4())}
+	store := db.Stores{ID: uuid.Must(uuid.NewV4()), Name: "Store", OwnerID: buyer.ID}
+	product := db.Products{ID: uuid.Must(uuid.NewV4()), Name: "Product", Price: money.New(1), StoreID: store.ID}
+	order := db.Orders{ID: uuid.Must(uuid.NewV4()), ProductID: product.ID, BuyerID: buyer.ID, Quantity: 1, Total: money.New(1), Status: status}
+	for _, row := range []interface{}{&buyer, &store, &product, &order} {
+		err = database.Create(row).Error
+		if err != nil {
+			t.Fatalf("creating %T: %v", row, err)
+		}
+	}
+
+	return s.(*orderState), database, order.ID
+}
+
+func TestCanTransition(t *testing.T) {
+	for _, tt := range []struct {
+		from db.OrderStatus
+		to   db.OrderStatus
+		want bool
+	}{
+		{from: db.OrderStatusPending, to: db.OrderStatusPaid, want: true},
+		{from: db.OrderStatusPending, to: db.OrderStatusCancelled, want: true},
+		{from: db.OrderStatusPaid, to: db.OrderStatusShipped, want: true},
+		{from: db.OrderStatusPaid, to: db.OrderStatusRefunded, want: true},
+		{from: db.OrderStatusShipped, to: db.OrderStatusCompleted, want: true},
+		{from: db.OrderStatusShipped, to: db.OrderStatusReleased, want: true},
+		{from: db.OrderStatusPending, to: db.OrderStatusShipped, want: false},
+		{from: db.OrderStatusPaid, to: db.OrderStatusPending, want: false},
+		{from: db.OrderStatusPaid, to: db.OrderStatusPaid, want: false},
+		{from: db.OrderStatusShipped, to: db.OrderStatusCancelled, want: false},
+		{from: db.OrderStatusCancelled, to: db.OrderStatusPaid, want: false},
+		{from: db.OrderStatusCompleted, to: db.OrderStatusRefunded, want: false},
+		{from: db.OrderStatusRefunded, to: db.OrderStatusPaid, want: false},
+		{from: db.OrderStatusReleased, to: db.OrderStatusRefunded, want: false},
+	} {
+		got := CanTransition(tt.from, tt.to)
+		if got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransitionRecordsHistory(t *testing.T) {
+	s, _, orderID := newOrderState(t, db.OrderStatusPending)
+
+	err := s.Transition(orderID, db.OrderStatusPaid, "chain", SourceChain)
+	if err != nil {
+		t.Fatalf("transitioning to paid: %v", err)
+	}
+	err = s.Transition(orderID, db.OrderStatusPending, "api", SourceAPI)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("transitioning paid to pending error = %v, want %v", err, ErrIllegalTransition)
+	}
+
+	history, err := s.History(orderID)
+	if err != nil {
+		t.Fatalf("reading history: %v", err)
+	}
+	if len(history) != 1 || history[0].FromStatus != db.OrderStatusPending || history[0].ToStatus != db.OrderStatusPaid {
+		t.Fatalf("history = %+v, want a single pending to paid change", history)
+	}
+}
+
+func TestTransitionUnknownOrder(t *testing.T) {
+	s, _, _ := newOrderState(t, db.OrderStatusPending)
+
+	err := s.Transition(uuid.Must(uuid.NewV4()), db.OrderStatusPaid, "chain", SourceChain)
+	if !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("transition error = %v, want %v", err, ErrOrderNotFound)
+	}
+}
+
+func TestLostRaceReturnsStatusChanged(t *testing.T) {
+	s, database, orderID := newOrderState(t, db.OrderStatusPending)
+
+	// Another writer cancels the order after this one read it as pending
+	err := database.Model(&db.Orders{}).Where("id = ?", orderID).Update("status", db.OrderStatusCancelled).Error
+	if err != nil {
+		t.Fatalf("cancelling order: %v", err)
+	}
+
+	err = database.Transaction(func(tx *gorm.DB) error {
+		return s.apply(tx, orderID, db.OrderStatusPending, db.OrderStatusPaid, "chain", SourceChain)
+	})
+	if !errors.Is(err, ErrStatusChanged) {
+		t.Fatalf("apply error = %v, want %v", err, ErrStatusChanged)
+	}
+
+	var order db.Orders
+	database.Where("id = ?", orderID).First(&order)
+	if order.Status != db.OrderStatusCancelled {
+		t.Fatalf("status = %s, want %s", order.Status, db.OrderStatusCancelled)
+	}
+
+	history, _ := s.History(orderID)
+	if len(history) != 0 {
+		t.Fatalf("history = %+v, want no change recorded for the lost race", history)
+	}
+}
+
+func TestRevertFromStaleStatus(t *testing.T) {
+	s, _, orderID := newOrderState(t, db.OrderStatusShipped)
+
+	err := s.Revert(orderID, db.OrderStatusPaid, db.OrderStatusPending, "chain", SourceChain)
+	if !errors.Is(err, ErrStatusChanged) {
+		t.Fatalf("revert error = %v, want %v", err, ErrStatusChanged)
+	}
+}
+
+func TestConcurrentTransitions(t *testing.T) {
+	s, database, orderID := newOrderState(t, db.OrderStatusPending)
+
+	// Paying and cancelling race, exactly one of them may win
+	targets := []db.OrderStatus{db.OrderStatusPaid, db.OrderStatusCancelled, db.OrderStatusPaid, db.OrderStatusCancelled}
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, to := range targets {
+		wg.Add(1)
+		go func(i int, to db.OrderStatus) {
+			defer wg.Done()
+			errs[i] = s.Transition(orderID, to, "test", SourceAPI)
+		}(i, to)
+	}
+	wg.Wait()
+
+	won := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case errors.Is(err, ErrStatusChanged), errors.Is(err, ErrIllegalTransition):
+		default:
+			t.Fatalf("transition to %s: %v", targets[i], err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d transitions succeeded, want 1", won)
+	}
+
+	var order db.Orders
+	database.Where("id = ?", orderID).First(&order)
+	history, _ := s.History(orderID)
+	if len(history) != 1 || history[0].ToStatus != order.Status {
+		t.Fatalf("history = %+v with status %s, want the single winning change", history, order.Status)
+	}
+}
@@ -0,0 +1,183 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+)
+
+const (
+	defaultRefreshInterval = 5 * time.Minute
+	// fiatDecimals is the precision display prices are rounded to
+	fiatDecimals = 2
+)
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrRatesUnavailable    = errors.New("exchange rates unavailable")
+)
+
+type (
+	// Pricing converts on-chain amounts to fiat display prices
+	Pricing interface {
+		// Convert returns the display price of an amount of a crypto currency in a fiat currency
+		Convert(amount money.Amount, decimals int, from string, to string) (*DisplayPrice, error)
+
+		// Refresh fetches the latest rates from the provider into the cache
+		Refresh() error
+
+		// Run refreshes the cached rates on every interval
+		Run()
+	}
+
+	// DisplayPrice is a fiat amount shown next to the canonical on-chain amount
+	DisplayPrice struct {
+		Currency string    `json:"currency"`
+		Amount   string    `json:"amount"`
+		Rate     string    `json:"rate"`
+		RatesAt  time.Time `json:"rates_at"`
+	}
+
+	pricing struct {
+		provider RateProvider
+		interval time.Duration
+
+		mu      sync.RWMutex
+		rates   Rates
+		ratesAt time.Time
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewPricing)
+	})
+}
+
+func NewPricing(i *do.Injector) (Pricing, error) {
+	cfg := do.MustInvoke[config.Config](i).GetPricing()
+
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(provider, cfg.RefreshInterval), nil
+}
+
+// New creates a pricing service around a rate provider, a nil provider disables fiat prices
+func New(provider RateProvider, interval time.Duration) Pricing {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+
+	return &pricing{
+		provider: provider,
+		interval: interval,
+	}
+}
+
+func newProvider(cfg config.PricingConfig) (RateProvider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileProvider(cfg.RatesFile), nil
+	case "coingecko":
+		return NewCoinGeckoProvider(cfg.CoinGeckoURL, cfg.CoinGeckoIDs, cfg.FiatCurrencies), nil
+	}
+
+	return nil, fmt.Errorf("unknown pricing provider %q", cfg.Provider)
+}
+
+func (p *pricing) Convert(amount money.Amount, decimals int, from string, to string) (*DisplayPrice, error) {
+	rates, ratesAt, err := p.cached()
+	if err != nil {
+		return nil, err
+	}
+
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+
+	rate, ok := rates[from][to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s to %s", ErrUnsupportedCurrency, from, to)
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	value := new(big.Rat).SetFrac(amount.Big(), scale)
+	value.Mul(value, rate)
+
+	return &DisplayPrice{
+		Currency: to,
+		Amount:   value.FloatString(fiatDecimals),
+		Rate:     rate.RatString(),
+		RatesAt:  ratesAt,
+	}, nil
+}
+
+// cached returns the cached rates, requests never wait on the provider, Run loads
+// them in the background
+func (p *pricing) cached() (Rates, time.Time, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.rates == nil {
+		return nil, time.Time{}, ErrRatesUnavailable
+	}
+
+	return p.rates, p.ratesAt, nil
+}
+
+func (p *pricing) Refresh() error {
+	if p.provider == nil {
+		return ErrRatesUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rates, err := p.provider.Rates(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rates = rates.normalize()
+	p.ratesAt = time.Now()
+
+	return nil
+}
+
+// Run keeps the last rates when a refresh fails, so display prices stay available
+// while the provider is down
+func (p *pricing) Run() {
+	if p.provider == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		err := p.Refresh()
+		if err != nil {
+			log.Println("Error refreshing exchange rates", err)
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,75 @@
+package pricing
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/money"
+)
+
+func TestConvert(t *testing.T) {
+	p := New(NewStaticProvider(Rates{"eth": {"eur": big.NewRat(312055, 100)}}), time.Minute)
+
+	// 0.25 ether
+	amount, _ := money.Parse("250000000000000000")
+
+	// Requests do not fetch rates themselves, they wait for a refresh
+	_, err := p.Convert(amount, money.EtherDecimals, "ETH", "EUR")
+	if !errors.Is(err, ErrRatesUnavailable) {
+		t.Fatalf("error before refresh = %v, want %v", err, ErrRatesUnavailable)
+	}
+	err = p.Refresh()
+	if err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+
+	price, err := p.Convert(amount, money.EtherDecimals, "ETH", "eur")
+	if err != nil {
+		t.Fatalf("converting: %v", err)
+	}
+	if price.Currency != "EUR" || price.Amount != "780.14" {
+		t.Errorf("price = %s %s, want EUR 780.14", price.Currency, price.Amount)
+	}
+
+	_, err = p.Convert(amount, money.EtherDecimals, "ETH", "GBP")
+	if !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("error = %v, want %v", err, ErrUnsupportedCurrency)
+	}
+}
+
+func TestConvertWithoutProvider(t *testing.T) {
+	_, err := New(nil, 0).Convert(money.New(1), money.EtherDecimals, "ETH", "EUR")
+	if !errors.Is(err, ErrRatesUnavailable) {
+		t.Errorf("error = %v, want %v", err, ErrRatesUnavailable)
+	}
+}
+
+func TestRefreshKeepsLastRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"ETH": {"USD": "3400"}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(NewFileProvider(path), time.Minute)
+	if err := p.Refresh(); err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+
+	os.Remove(path)
+	if err := p.Refresh(); err == nil {
+		t.Fatal("refresh without rates file succeeded")
+	}
+
+	price, err := p.Convert(money.New(2_000_000_000_000_000_000), money.EtherDecimals, "ETH", "USD")
+	if err != nil {
+		t.Fatalf("converting: %v", err)
+	}
+	if price.Amount != "6800.00" {
+		t.Errorf("amount = %s, want 6800.00", price.Amount)
+	}
+}
@@ -0,0 +1,171 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultCoinGeckoURL = "https://api.coingecko.com/api/v3"
+
+var defaultFiatCurrencies = []string{"EUR", "USD"}
+
+type (
+	// RateProvider fetches exchange rates
+	RateProvider interface {
+		// Rates returns the current rates
+		Rates(ctx context.Context) (Rates, error)
+	}
+
+	// Rates maps a crypto currency symbol to its price in each fiat currency,
+	// Rates["ETH"]["EUR"] is the price of one ether in euros
+	Rates map[string]map[string]*big.Rat
+
+	staticProvider struct {
+		rates Rates
+	}
+
+	fileProvider struct {
+		path string
+	}
+
+	coinGeckoProvider struct {
+		baseURL string
+		// ids maps currency symbols to CoinGecko coin ids
+		ids    map[string]string
+		fiats  []string
+		client *http.Client
+	}
+)
+
+// NewStaticProvider returns a provider that always serves the same rates
+func NewStaticProvider(rates Rates) RateProvider {
+	return &staticProvider{rates: rates}
+}
+
+func (s *staticProvider) Rates(ctx context.Context) (Rates, error) {
+	return s.rates, nil
+}
+
+// NewFileProvider returns a provider reading rates from a JSON file on every refresh,
+// formatted as {"ETH": {"EUR": "3120.55", "USD": "3400"}}
+func NewFileProvider(path string) RateProvider {
+	return &fileProvider{path: path}
+}
+
+func (f *fileProvider) Rates(ctx context.Context) (Rates, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rates file %s: %w", f.path, err)
+	}
+
+	var raw map[string]map[string]json.Number
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing rates file %s: %w", f.path, err)
+	}
+
+	rates := Rates{}
+	for crypto, quotes := range raw {
+		for fiat, quote := range quotes {
+			rate, ok := new(big.Rat).SetString(quote.String())
+			if !ok {
+				return nil, fmt.Errorf("invalid %s/%s rate %q", crypto, fiat, quote)
+			}
+
+			rates.set(crypto, fiat, rate)
+		}
+	}
+
+	return rates, nil
+}
+
+// NewCoinGeckoProvider returns a provider using the CoinGecko simple price API
+func NewCoinGeckoProvider(baseURL string, ids map[string]string, fiats []string) RateProvider {
+	if baseURL == "" {
+		baseURL = defaultCoinGeckoURL
+	}
+	if len(fiats) == 0 {
+		fiats = defaultFiatCurrencies
+	}
+
+	return &coinGeckoProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		ids:     ids,
+		fiats:   fiats,
+		client:  &http.Client{},
+	}
+}
+
+func (c *coinGeckoProvider) Rates(ctx context.Context) (Rates, error) {
+	ids := make([]string, 0, len(c.ids))
+	for _, id := range c.ids {
+		ids = append(ids, id)
+	}
+
+	query := url.Values{}
+	query.Set("ids", strings.Join(ids, ","))
+	query.Set("vs_currencies", strings.ToLower(strings.Join(c.fiats, ",")))
+	query.Set("precision", "full")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/simple/price?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coingecko returned %s", res.Status)
+	}
+
+	var prices map[string]map[string]json.Number
+	err = json.NewDecoder(res.Body).Decode(&prices)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := Rates{}
+	for symbol, id := range c.ids {
+		for fiat, price := range prices[id] {
+			rate, ok := new(big.Rat).SetString(price.String())
+			if !ok {
+				continue
+			}
+
+			rates.set(symbol, fiat, rate)
+		}
+	}
+
+	return rates, nil
+}
+
+func (r Rates) set(crypto string, fiat string, rate *big.Rat) {
+	crypto, fiat = strings.ToUpper(crypto), strings.ToUpper(fiat)
+	if r[crypto] == nil {
+		r[crypto] = map[string]*big.Rat{}
+	}
+
+	r[crypto][fiat] = rate
+}
+
+// normalize returns a copy with upper case symbols, so lookups ignore case
+func (r Rates) normalize() Rates {
+	normalized := Rates{}
+	for crypto, quotes := range r {
+		for fiat, rate := range quotes {
+			normalized.set(crypto, fiat, rate)
+		}
+	}
+
+	return normalized
+}
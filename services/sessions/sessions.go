@@ -0,0 +1,330 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TechXTT/bazaar-backend/pkg/app"
+	"github.com/TechXTT/bazaar-backend/pkg/rbac"
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/jwt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/mikestefanello/hooks"
+	"github.com/samber/do"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session revoked")
+)
+
+type (
+	// Sessions issues access and refresh tokens for user logins and revokes them
+	Sessions interface {
+		// Create starts a session for a user who just logged in
+		Create(userID uuid.UUID, client Client) (*Tokens, error)
+
+		// Refresh exchanges a refresh token for new tokens, the refresh token can only be used once
+		Refresh(refreshToken string, client Client) (*Tokens, error)
+
+		// Authenticate validates an access token of an active session and returns what it grants
+		Authenticate(accessToken string) (*jwt.Access, error)
+
+		// List returns the active sessions of a user
+		List(userID string) ([]Session, error)
+
+		// Revoke ends a session of a user
+		Revoke(userID string, sessionID string) error
+
+		// RevokeAll ends every session of a user
+		RevokeAll(userID string) error
+	}
+
+	// Client describes where a session is used from
+	Client struct {
+		UserAgent string
+		IPAddress string
+	}
+
+	// Tokens are returned on login and refresh
+	Tokens struct {
+		Token        string    `json:"token"`
+		RefreshToken string    `json:"refresh_token"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}
+
+	// Session is an active session as shown to its user
+	Session struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		IPAddress  string    `json:"ip_address"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		Current    bool      `json:"current"`
+	}
+
+	sessions struct {
+		db   db.DB
+		jwks jwt.Jwks
+
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+	}
+)
+
+func init() {
+	// Provide dependencies during app boot process
+	app.HookBoot.Listen(func(e hooks.Event[*do.Injector]) {
+		do.Provide(e.Msg, NewSessions)
+	})
+}
+
+func NewSessions(i *do.Injector) (Sessions, error) {
+	cfg := do.MustInvoke[config.Config](i).GetJWT()
+
+	s := &sessions{
+		db:              do.MustInvoke[db.DB](i),
+		jwks:            do.MustInvoke[jwt.Jwks](i),
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+	}
+	if s.accessTokenTTL <= 0 {
+		s.accessTokenTTL = defaultAccessTokenTTL
+	}
+	if s.refreshTokenTTL <= 0 {
+		s.refreshTokenTTL = defaultRefreshTokenTTL
+	}
+
+	return s, nil
+}
+
+func (s *sessions) Create(userID uuid.UUID, client Client) (*Tokens, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := db.Sessions{
+		ID:               id,
+		UserID:           userID,
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        client.UserAgent,
+		IPAddress:        client.IPAddress,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(s.refreshTokenTTL),
+	}
+
+	result := s.db.DB().Create(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return s.tokens(session, refreshToken)
+}
+
+func (s *sessions) Refresh(refreshToken string, client Client) (*Tokens, error) {
+	database := s.db.DB()
+	hash := hashToken(refreshToken)
+
+	var session db.Sessions
+	result := database.Where("refresh_token_hash = ?", hash).Limit(1).Find(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, s.detectReuse(hash)
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if !session.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: session expired", ErrInvalidRefreshToken)
+	}
+
+	rotated, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.PreviousTokenHash = hash
+	session.RefreshTokenHash = hashToken(rotated)
+	session.UserAgent = client.UserAgent
+	session.IPAddress = client.IPAddress
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.refreshTokenTTL)
+
+	// Matching on the old hash makes only one of two concurrent refreshes succeed
+	result = database.Model(&db.Sessions{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  session.RefreshTokenHash,
+			"previous_token_hash": session.PreviousTokenHash,
+			"user_agent":          session.UserAgent,
+			"ip_address":          session.IPAddress,
+			"last_used_at":        session.LastUsedAt,
+			"expires_at":          session.ExpiresAt,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.tokens(session, rotated)
+}
+
+// detectReuse revokes the session a rotated out refresh token belonged to, as it being used
+// again means it leaked and whoever holds the current one cannot be trusted either
+func (s *sessions) detectReuse(hash string) error {
+	result := s.db.DB().Model(&db.Sessions{}).
+		Where("previous_token_hash = ? AND revoked_at IS NULL", hash).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return fmt.Errorf("%w: refresh token reused, session revoked", ErrInvalidRefreshToken)
+	}
+
+	return ErrInvalidRefreshToken
+}
+
+func (s *sessions) Authenticate(accessToken string) (*jwt.Access, error) {
+	access, err := s.jwks.ValidateAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var session db.Sessions
+	result := s.db.DB().Where("id = ? AND user_id = ?", access.SessionID, access.UserID).Limit(1).Find(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrSessionNotFound
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+
+	return access, nil
+}
+
+func (s *sessions) List(userID string) ([]Session, error) {
+	var rows []db.Sessions
+	result := s.db.DB().
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at desc").
+		Find(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sessions := make([]Session, len(rows))
+	for i, row := range rows {
+		sessions[i] = Session{
+			ID:         row.ID.String(),
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IPAddress,
+			CreatedAt:  row.CreatedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+		}
+	}
+
+	return sessions, nil
+}
+
+func (s *sessions) Revoke(userID string, sessionID string) error {
+	result := s.db.DB().Model(&db.Sessions{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *sessions) RevokeAll(userID string) error {
+	return s.db.DB().Model(&db.Sessions{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *sessions) tokens(session db.Sessions, refreshToken string) (*Tokens, error) {
+	// The role is read on every refresh, so a changed role is picked up by the next access token
+	var user db.Users
+	result := s.db.DB().Select("role").Where("id = ?", session.UserID).Limit(1).Find(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	role, err := rbac.Parse(user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	for _, permission := range role.Permissions() {
+		permissions = append(permissions, string(permission))
+	}
+
+	expiresAt := time.Now().Add(s.accessTokenTTL)
+
+	token, err := s.jwks.GenerateAccessToken(jwt.Access{
+		UserID:      session.UserID.String(),
+		SessionID:   session.ID.String(),
+		Role:        string(role),
+		Permissions: permissions,
+	}, s.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.Truncate(time.Second),
+	}, nil
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what is stored of a refresh token, so a database leak does not leak sessions
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,219 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/db"
+	"github.com/TechXTT/bazaar-backend/services/jwt"
+	"github.com/glebarez/sqlite"
+	"github.com/gofrs/uuid/v5"
+	"github.com/samber/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDB struct {
+	db *gorm.DB
+}
+
+func (d *testDB) DB() *gorm.DB {
+	return d.db
+}
+
+var testClient = Client{UserAgent: "test", IPAddress: "127.0.0.1"}
+
+func newTestSessions(t *testing.T) (Sessions, *gorm.DB) {
+	t.Helper()
+
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sessions.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	db.Migrate(database)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("encoding public key: %v", err)
+	}
+
+	i := do.New()
+	do.ProvideValue[db.DB](i, &testDB{db: database})
+	do.ProvideValue[config.Config](i, &config.Base{
+		JWT: config.JWTConfig{
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+			PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		},
+	})
+	do.Provide(i, jwt.NewJwk)
+
+	s, err := NewSessions(i)
+	if err != nil {
+		t.Fatalf("creating sessions: %v", err)
+	}
+
+	return s, database
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	s, _ := newTestSessions(t)
+	userID := uuid.Must(uuid.NewV4())
+
+	tokens, err := s.Create(userID, testClient)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	access, err := s.Authenticate(tokens.Token)
+	if err != nil {
+		t.Fatalf("authenticating: %v", err)
+	}
+	if access.UserID != userID.String() {
+		t.Errorf("user id = %s, want %s", access.UserID, userID)
+	}
+	sessionID := access.SessionID
+
+	refreshed, err := s.Refresh(tokens.RefreshToken, testClient)
+	if err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	refreshedAccess, err := s.Authenticate(refreshed.Token)
+	if err != nil {
+		t.Fatalf("authenticating refreshed token: %v", err)
+	}
+	if refreshedAccess.SessionID != sessionID {
+		t.Errorf("session id = %s, want %s", refreshedAccess.SessionID, sessionID)
+	}
+
+	sessions, err := s.List(userID.String())
+	if err != nil {
+		t.Fatalf("listing sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sessionID {
+		t.Errorf("sessions = %+v, want only %s", sessions, sessionID)
+	}
+}
+
+func TestReusedRefreshTokenRevokesSession(t *testing.T) {
+	s, _ := newTestSessions(t)
+
+	tokens, err := s.Create(uuid.Must(uuid.NewV4()), testClient)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	refreshed, err := s.Refresh(tokens.RefreshToken, testClient)
+	if err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+
+	_, err = s.Refresh(tokens.RefreshToken, testClient)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reusing refresh token = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	_, err = s.Authenticate(refreshed.Token)
+	if !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("authenticating after reuse = %v, want %v", err, ErrSessionRevoked)
+	}
+	_, err = s.Refresh(refreshed.RefreshToken, testClient)
+	if !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("refreshing after reuse = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestRevokedSessionIsRejected(t *testing.T) {
+	s, _ := newTestSessions(t)
+	userID := uuid.Must(uuid.NewV4())
+
+	first, err := s.Create(userID, testClient)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	second, err := s.Create(userID, testClient)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	access, err := s.Authenticate(first.Token)
+	if err != nil {
+		t.Fatalf("authenticating: %v", err)
+	}
+	firstID := access.SessionID
+
+	// Another user cannot revoke the session
+	err = s.Revoke(uuid.Must(uuid.NewV4()).String(), firstID)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking as another user = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	err = s.Revoke(userID.String(), firstID)
+	if err != nil {
+		t.Fatalf("revoking: %v", err)
+	}
+
+	_, err = s.Authenticate(first.Token)
+	if !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("authenticating revoked session = %v, want %v", err, ErrSessionRevoked)
+	}
+	_, err = s.Authenticate(second.Token)
+	if err != nil {
+		t.Errorf("authenticating other session: %v", err)
+	}
+
+	err = s.RevokeAll(userID.String())
+	if err != nil {
+		t.Fatalf("revoking all: %v", err)
+	}
+	_, err = s.Authenticate(second.Token)
+	if !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("authenticating after revoking all = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestRefreshPicksUpRoleChanges(t *testing.T) {
+	s, database := newTestSessions(t)
+	user := db.Users{ID: uuid.Must(uuid.NewV4()), FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Role: "moderator"}
+	database.Create(&user)
+
+	tokens, err := s.Create(user.ID, testClient)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	access, err := s.Authenticate(tokens.Token)
+	if err != nil {
+		t.Fatalf("authenticating: %v", err)
+	}
+	if access.Role != "moderator" || len(access.Permissions) != 2 {
+		t.Errorf("access = %+v, want moderator permissions", access)
+	}
+
+	database.Model(&db.Users{}).Where("id = ?", user.ID).Update("role", "user")
+
+	refreshed, err := s.Refresh(tokens.RefreshToken, testClient)
+	if err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+	access, err = s.Authenticate(refreshed.Token)
+	if err != nil {
+		t.Fatalf("authenticating refreshed token: %v", err)
+	}
+	if access.Role != "user" || len(access.Permissions) != 0 {
+		t.Errorf("access = %+v, want no permissions", access)
+	}
+}
@@ -1,11 +1,13 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/TechXTT/bazaar-backend/pkg/app"
 	"github.com/TechXTT/bazaar-backend/services/config"
+	"github.com/TechXTT/bazaar-backend/services/jwt"
 	"github.com/gorilla/mux"
 	"github.com/mikestefanello/hooks"
 	"github.com/rs/cors"
@@ -18,8 +20,11 @@ type (
 	}
 
 	web struct {
+		// router serves the whole server, handler the /api routes registered by modules
+		router  *mux.Router
 		handler *mux.Router
 		cfg     config.Config
+		jwks    jwt.Jwks
 	}
 )
 
@@ -32,9 +37,13 @@ func init() {
 }
 
 func NewWeb(i *do.Injector) (Web, error) {
+	router := mux.NewRouter()
+
 	w := &web{
-		handler: mux.NewRouter().PathPrefix("/api").Subrouter(),
+		router:  router,
+		handler: router.PathPrefix("/api").Subrouter(),
 		cfg:     do.MustInvoke[config.Config](i),
+		jwks:    do.MustInvoke[jwt.Jwks](i),
 	}
 	w.buildRouter()
 
@@ -48,6 +57,13 @@ func (w *web) buildRouter() {
 		w.Write([]byte("OK"))
 	}).Methods(http.MethodGet)
 
+	// Other services verify Bazaar tokens with the published keys, the well-known path is outside /api
+	w.router.HandleFunc("/.well-known/jwks.json", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(rw).Encode(w.jwks.KeySet())
+	}).Methods(http.MethodGet)
+
 	HookBuildRouter.Dispatch(w.handler)
 }
 
@@ -62,7 +78,7 @@ func (w *web) Start() error {
 		ExposedHeaders:   w.cfg.GetHTTP().ExposedHeaders,
 	})
 
-	handler := c.Handler(w.handler)
+	handler := c.Handler(w.router)
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", httpCfg.Port),
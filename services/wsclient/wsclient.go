@@ -1,6 +1,8 @@
 package wsclient
 
 import (
+	"fmt"
+
 	"github.com/TechXTT/bazaar-backend/pkg/app"
 	"github.com/TechXTT/bazaar-backend/services/config"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -11,8 +13,11 @@ import (
 type (
 	// Service is the wsclient service interface
 	WsClient interface {
-		// InitEthClient initializes the ethereum client
+		// InitEthClient initializes the ethereum client of the default network
 		InitEthClient() *ethclient.Client
+
+		// Dial connects a new ethereum client to a network, returning the error instead of panicking
+		Dial(chainID uint64) (*ethclient.Client, error)
 	}
 
 	wsclient struct {
@@ -34,10 +39,19 @@ func NewWsClient(i *do.Injector) (WsClient, error) {
 }
 
 func (w *wsclient) InitEthClient() *ethclient.Client {
-	client, err := ethclient.Dial(w.cfg.GetWs().ETH_URL)
+	client, err := w.Dial(w.cfg.GetWs().DefaultNetwork().ChainID)
 	if err != nil {
 		panic(err)
 	}
 
 	return client
 }
+
+func (w *wsclient) Dial(chainID uint64) (*ethclient.Client, error) {
+	network, ok := w.cfg.GetWs().Network(chainID)
+	if !ok {
+		return nil, fmt.Errorf("network %d is not configured", chainID)
+	}
+
+	return ethclient.Dial(network.RPCURL)
+}